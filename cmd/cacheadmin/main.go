@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	remoteexecution_v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/mwitkow/bazel-distcache/common/sharedflags"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/proto/distcache/admin"
+	logrus "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+)
+
+var (
+	serverAddress = sharedflags.Set.String("server", "localhost:10101", "gRPC address of the localcache or distcache to talk to")
+	instanceName  = sharedflags.Set.String("instance_name", "", "instance of the cache to operate on")
+	outputPath    = sharedflags.Set.String("output", "", "file to write downloaded blobs to, stdout if empty")
+)
+
+const usage = `usage: cacheadmin [flags] <command> [args]
+
+commands:
+  show-action <hash>[/<size>]    prints the ActionResult of the action as text proto
+  outputs <hash>[/<size>]        lists the outputs of the action with their digests and sizes
+  get-blob <hash>/<size>         downloads the blob, see --output
+  delete-action <hash>[/<size>]  deletes the ActionResult of the action
+  delete-blob <hash>[/<size>]    deletes the blob
+  stats                          prints the number and size of stored ActionResults and blobs
+`
+
+// cacheadmin inspects and manipulates the contents of a running localcache or distcache.
+// All commands but get-blob need the admin service to be enabled on the server (--admin_service_enabled).
+func main() {
+	logrus.SetOutput(os.Stderr)
+	sharedflags.Set.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		sharedflags.Set.PrintDefaults()
+	}
+	if err := sharedflags.Set.Parse(os.Args[1:]); err != nil {
+		logrus.Fatalf("failed parsing flags: %v", err)
+	}
+	args := sharedflags.Set.Args()
+	if len(args) == 0 {
+		sharedflags.Set.Usage()
+		os.Exit(2)
+	}
+	conn, err := grpc.Dial(*serverAddress, grpc.WithInsecure())
+	if err != nil {
+		logrus.Fatalf("failed dialing %v: %v", *serverAddress, err)
+	}
+	defer conn.Close()
+
+	if err := run(context.Background(), conn, args[0], args[1:]); err != nil {
+		logrus.Fatalf("%v failed: %v", args[0], err)
+	}
+}
+
+func run(ctx context.Context, conn *grpc.ClientConn, command string, args []string) error {
+	if command == "stats" {
+		resp, err := distcache_admin.NewAdminClient(conn).GetStats(ctx, &distcache_admin.GetStatsRequest{InstanceName: *instanceName})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("action results: %d\nblobs: %d\nblob bytes: %d\n", resp.ActionResults, resp.Blobs, resp.BlobBytes)
+		return nil
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("expected a single digest argument")
+	}
+	digest, err := parseDigest(args[0])
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "show-action":
+		actionResult, err := getActionResult(ctx, conn, digest)
+		if err != nil {
+			return err
+		}
+		return proto.MarshalText(os.Stdout, actionResult)
+	case "outputs":
+		actionResult, err := getActionResult(ctx, conn, digest)
+		if err != nil {
+			return err
+		}
+		printOutputs(actionResult)
+		return nil
+	case "get-blob":
+		return getBlob(ctx, conn, digest)
+	case "delete-action":
+		_, err := distcache_admin.NewAdminClient(conn).DeleteActionResult(ctx, &distcache_admin.DeleteActionResultRequest{
+			InstanceName: *instanceName,
+			ActionDigest: digest,
+		})
+		return err
+	case "delete-blob":
+		_, err := distcache_admin.NewAdminClient(conn).DeleteBlob(ctx, &distcache_admin.DeleteBlobRequest{
+			InstanceName: *instanceName,
+			Digest:       digest,
+		})
+		return err
+	default:
+		return fmt.Errorf("unknown command, see --help")
+	}
+}
+
+// getActionResult reads the stored ActionResult through the admin service, as the ActionCache API may hide or even
+// delete it. It's converted to v2, so that fields missing in v1test (e.g. symlinks) are shown too.
+func getActionResult(ctx context.Context, conn *grpc.ClientConn, digest *remoteexecution.Digest) (*remoteexecution_v2.ActionResult, error) {
+	actionResult, err := distcache_admin.NewAdminClient(conn).GetActionResult(ctx, &distcache_admin.GetActionResultRequest{
+		InstanceName: *instanceName,
+		ActionDigest: digest,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return util.ActionResultToV2(actionResult)
+}
+
+func printOutputs(actionResult *remoteexecution_v2.ActionResult) {
+	for _, f := range actionResult.OutputFiles {
+		executable := ""
+		if f.IsExecutable {
+			executable = " (executable)"
+		}
+		fmt.Printf("file\t%v\t%v/%d%v\n", f.Path, f.GetDigest().GetHash(), f.GetDigest().GetSizeBytes(), executable)
+	}
+	for _, d := range actionResult.OutputDirectories {
+		fmt.Printf("dir\t%v\ttree %v/%d\n", d.Path, d.GetTreeDigest().GetHash(), d.GetTreeDigest().GetSizeBytes())
+	}
+	for _, s := range append(actionResult.OutputFileSymlinks, actionResult.OutputDirectorySymlinks...) {
+		fmt.Printf("symlink\t%v\t-> %v\n", s.Path, s.Target)
+	}
+	if d := actionResult.StdoutDigest; d != nil {
+		fmt.Printf("stdout\t\t%v/%d\n", d.Hash, d.SizeBytes)
+	}
+	if d := actionResult.StderrDigest; d != nil {
+		fmt.Printf("stderr\t\t%v/%d\n", d.Hash, d.SizeBytes)
+	}
+	fmt.Printf("exit code: %d\n", actionResult.ExitCode)
+}
+
+func getBlob(ctx context.Context, conn *grpc.ClientConn, digest *remoteexecution.Digest) error {
+	resourceName := fmt.Sprintf("blobs/%v/%d", digest.Hash, digest.SizeBytes)
+	if *instanceName != "" {
+		resourceName = *instanceName + "/" + resourceName
+	}
+	stream, err := bytestream.NewByteStreamClient(conn).Read(ctx, &bytestream.ReadRequest{ResourceName: resourceName})
+	if err != nil {
+		return err
+	}
+	out := os.Stdout
+	if *outputPath != "" {
+		if out, err = os.Create(*outputPath); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := out.Write(resp.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// parseDigest parses `<hash>[/<size>]`. The size doesn't matter for looking up content, so it defaults to 0.
+func parseDigest(arg string) (*remoteexecution.Digest, error) {
+	parts := strings.SplitN(arg, "/", 2)
+	digest := &remoteexecution.Digest{Hash: parts[0]}
+	if len(parts) == 2 {
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad size in digest %q: %v", arg, err)
+		}
+		digest.SizeBytes = size
+	}
+	return digest, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwitkow/bazel-distcache/common/sharedflags"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"github.com/mwitkow/bazel-distcache/stores/fsck"
+	logrus "github.com/sirupsen/logrus"
+)
+
+var (
+	fix = sharedflags.Set.String("fix", fsck.FixNone,
+		"what to do with corrupt blobs and ActionResults: 'none' (only report), 'quarantine' or 'delete'")
+)
+
+// cachefsck checks the on-disk stores of a stopped localcache or distcache, using the same store flags as them.
+// It exits with a non-zero status if problems were found that weren't fixed.
+func main() {
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(logrus.InfoLevel)
+	if err := sharedflags.Set.Parse(os.Args); err != nil {
+		logrus.Fatalf("failed parsing flags: %v", err)
+	}
+
+	blobBackend, err := blob.OpenBackendFromFlags()
+	if err != nil {
+		logrus.Fatalf("failed opening blob store: %v", err)
+	}
+	actionBackend, err := action.OpenBackendFromFlags()
+	if err != nil {
+		logrus.Fatalf("failed opening action store: %v", err)
+	}
+	onDiskBlobs, isOnDisk := blobBackend.(*blob.OnDiskBackend)
+	if !isOnDisk {
+		logrus.Fatalf("only file:// blob stores can be checked")
+	}
+	onDiskActions, isOnDisk := actionBackend.(*action.OnDiskBackend)
+	if !isOnDisk {
+		logrus.Fatalf("only file:// action stores can be checked")
+	}
+
+	reports, err := fsck.CheckAll(onDiskBlobs, onDiskActions, *fix)
+	unfixed := 0
+	for _, r := range reports {
+		for _, p := range r.Problems {
+			fmt.Printf("instance %q: %v\n", r.InstanceName, p)
+			if !p.Fixed {
+				unfixed++
+			}
+		}
+		fmt.Printf("instance %q: checked %d blobs and %d actions, found %d problems and %d files of interrupted writes\n",
+			r.InstanceName, r.Blobs, r.Actions, len(r.Problems), r.StagingFiles)
+	}
+	if err != nil {
+		logrus.Fatalf("fsck failed: %v", err)
+	}
+	if unfixed > 0 {
+		os.Exit(1)
+	}
+}
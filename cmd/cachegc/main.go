@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwitkow/bazel-distcache/common/sharedflags"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"github.com/mwitkow/bazel-distcache/stores/gc"
+	_ "github.com/mwitkow/bazel-distcache/stores/remote" // registers the grpc:// blob store backend
+	_ "github.com/mwitkow/bazel-distcache/stores/s3"     // registers the s3:// store backends
+	logrus "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+var (
+	dryRun = sharedflags.Set.Bool("dry_run", false, "only report the blobs that would be deleted")
+)
+
+// cachegc collects the garbage of the stores of a stopped localcache or distcache once, using the same
+// store flags as them.
+func main() {
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(logrus.InfoLevel)
+	if err := sharedflags.Set.Parse(os.Args); err != nil {
+		logrus.Fatalf("failed parsing flags: %v", err)
+	}
+
+	blobBackend, err := blob.OpenBackendFromFlags()
+	if err != nil {
+		logrus.Fatalf("failed opening blob store: %v", err)
+	}
+	actionBackend, err := action.OpenBackendFromFlags()
+	if err != nil {
+		logrus.Fatalf("failed opening action store: %v", err)
+	}
+
+	reports, err := gc.CollectAll(context.Background(),
+		blob.NewPerInstanceForBackend(blobBackend),
+		action.NewPerInstanceForBackend(actionBackend),
+		*dryRun)
+	verb := "deleted"
+	if *dryRun {
+		verb = "would delete"
+	}
+	for _, r := range reports {
+		if *dryRun {
+			for _, d := range r.Unreferenced {
+				fmt.Printf("%q\t%v/%d\n", r.InstanceName, d.Hash, d.SizeBytes)
+			}
+		}
+		fmt.Printf("instance %q: %d actions reference %d blobs, %s %d of %d blobs (%d bytes)\n",
+			r.InstanceName, r.Actions, r.ReferencedBlobs, verb, len(r.Unreferenced), r.Blobs, r.UnreferencedBytes)
+	}
+	if err != nil {
+		logrus.Fatalf("gc failed: %v", err)
+	}
+}
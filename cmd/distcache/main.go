@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/mwitkow/bazel-distcache/common/daemon"
+	"github.com/mwitkow/bazel-distcache/common/sharedflags"
+	logrus "github.com/sirupsen/logrus"
+)
+
+var (
+	grpcAddress         = sharedflags.Set.String("grpc_address", "0.0.0.0:10201", "grpc (localcache and bazel) address to listen on")
+	httpAddress         = sharedflags.Set.String("http_address", "localhost:10200", "http (debug, including pprof and metrics) address to listen on, only expose it to trusted networks")
+	adminServiceEnabled = sharedflags.Set.Bool("admin_service_enabled", false, "serves the Admin service used by cacheadmin for store statistics and deleting cache contents, anyone reaching the gRPC address can use it")
+)
+
+// storageFlagDefaults are the distcache-specific defaults of the store flags shared with localcache.
+// A shared cache is long-lived, so it shouldn't live in /tmp by default.
+var storageFlagDefaults = map[string]string{
+	"blobstore_ondisk_path":   "/var/cache/distcache/blobstore",
+	"actionstore_ondisk_path": "/var/cache/distcache/actionstore",
+	// Expiring failed results need to know when they were stored across restarts too.
+	"actioncache_failed_results_path": "/var/cache/distcache/failed-results",
+}
+
+func main() {
+	logrus.SetOutput(os.Stdout)
+	logrus.SetLevel(logrus.InfoLevel)
+	for name, value := range storageFlagDefaults {
+		flag := sharedflags.Set.Lookup(name)
+		if flag == nil {
+			logrus.Fatalf("storage flag %v is not registered", name)
+		}
+		if err := flag.Value.Set(value); err != nil {
+			logrus.Fatalf("failed setting default of flag %v: %v", name, err)
+		}
+		flag.DefValue = value
+	}
+	if err := sharedflags.Set.Parse(os.Args); err != nil {
+		logrus.Fatalf("failed parsing flags: %v", err)
+	}
+
+	grpcListener, err := net.Listen("tcp", *grpcAddress)
+	if err != nil {
+		logrus.Fatalf("failed listening on %v: %v", *grpcAddress, err)
+	}
+	httpListener, err := net.Listen("tcp", *httpAddress)
+	if err != nil {
+		logrus.Fatalf("failed listening on %v: %v", *httpAddress, err)
+	}
+	opts := daemon.Options{
+		Name:                "distcache",
+		AdminServiceEnabled: *adminServiceEnabled,
+		DebugInfo: func(w io.Writer) {
+			fmt.Fprintf(w, "Serving the remote cache for localcache (and bazel) on: %v\n", grpcListener.Addr().String())
+		},
+	}
+
+	if err := daemon.Serve(grpcListener, httpListener, opts); err != nil {
+		logrus.Fatalf("%v", err)
+	}
+}
@@ -2,29 +2,24 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"net"
-	"net/http"
-	_ "net/http/pprof" //registers "/debug/pprof"
 	"os"
 
-	"github.com/grpc-ecosystem/go-grpc-middleware"
-	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/mwitkow/bazel-distcache/common/daemon"
 	"github.com/mwitkow/bazel-distcache/common/sharedflags"
-	"github.com/mwitkow/bazel-distcache/service/actioncache"
-	"github.com/mwitkow/bazel-distcache/service/cas"
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/mwitkow/bazel-distcache/common/util"
 	logrus "github.com/sirupsen/logrus"
-	_ "golang.org/x/net/trace" // registers /debug/requests
-	"google.golang.org/genproto/googleapis/bytestream"
-	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
 	"google.golang.org/grpc"
 )
 
 var (
-	grpcPort           = sharedflags.Set.Int32("grpc_port", 10101, "grpc (bazel) port to run on")
-	httpPort           = sharedflags.Set.Int32("http_port", 10100, "http (debug) port to run on")
-	grpcTracingEnabled = sharedflags.Set.Bool("grpc_tracing_enabled", false, "traces whole requests in /debug/request (expensive due to blobs)")
+	grpcPort            = sharedflags.Set.Int32("grpc_port", 10101, "grpc (bazel) port to run on")
+	httpPort            = sharedflags.Set.Int32("http_port", 10100, "http (debug) port to run on")
+	adminServiceEnabled = sharedflags.Set.Bool("admin_service_enabled", true, "serves the Admin service used by cacheadmin for store statistics and deleting cache contents")
+	httpCachePort       = sharedflags.Set.Int32("http_cache_port", 0, "port to serve bazel's HTTP cache protocol on, disabled if 0")
+	upstreamAddress     = sharedflags.Set.String("upstream", "", "gRPC address of an upstream cache (e.g. distcache) consulted on local misses, disabled if empty")
 )
 
 func main() {
@@ -42,44 +37,34 @@ func main() {
 	if err != nil {
 		logrus.Fatalf("failed listening on 127.0.0.1:%d: %v", *httpPort, err)
 	}
+	opts := daemon.Options{
+		Name:                "localcache",
+		AdminServiceEnabled: *adminServiceEnabled,
+		DebugInfo: func(w io.Writer) {
+			fmt.Fprintf(w, "Use command:\n")
+			fmt.Fprintf(w, "\tbazel --host_jvm_args=-Dbazel.DigestFunction=%v --spawn_strategy=remote --remote_cache=localhost:%d build",
+				util.SupportedDigestFunctions()[0].Name, *grpcPort)
+		},
+	}
+	if *httpCachePort != 0 {
+		opts.HTTPCacheListener, err = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", *httpCachePort))
+		if err != nil {
+			logrus.Fatalf("failed listening on 127.0.0.1:%d: %v", *httpCachePort, err)
+		}
+	}
+	if *upstreamAddress != "" {
+		opts.Upstream, err = grpc.Dial(*upstreamAddress,
+			grpc.WithInsecure(),
+			grpc.WithUnaryInterceptor(grpc_prometheus.UnaryClientInterceptor),
+			grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor),
+		)
+		if err != nil {
+			logrus.Fatalf("failed dialing upstream %v: %v", *upstreamAddress, err)
+		}
+		logrus.Infof("using upstream cache: %v", *upstreamAddress)
+	}
 
-	logrusEntry := logrus.NewEntry(logrus.StandardLogger())
-	grpc_logrus.ReplaceGrpcLogger(logrusEntry)
-	grpcServer := grpc.NewServer(
-		grpc_middleware.WithUnaryServerChain(
-			grpc_prometheus.UnaryServerInterceptor,
-			grpc_logrus.UnaryServerInterceptor(logrusEntry),
-		),
-		grpc_middleware.WithStreamServerChain(
-			grpc_prometheus.StreamServerInterceptor,
-			grpc_logrus.StreamServerInterceptor(logrusEntry),
-		),
-	)
-	grpc.EnableTracing = *grpcTracingEnabled
-
-	casInstance := cas.NewLocal()
-	remoteexecution.RegisterActionCacheServer(grpcServer, actioncache.NewLocal())
-	remoteexecution.RegisterContentAddressableStorageServer(grpcServer, casInstance)
-	bytestream.RegisterByteStreamServer(grpcServer, casInstance)
-
-	grpc_prometheus.Register(grpcServer)
-
-	http.Handle("/metrics", prometheus.UninstrumentedHandler())
-	http.Handle("/", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
-		resp.Header().Set("content-type", "text/plain")
-		resp.WriteHeader(http.StatusOK)
-		fmt.Fprintf(resp, "Debug interface of localcache\n")
-		fmt.Fprintf(resp, "Use command:\n")
-		fmt.Fprintf(resp, "\tbazel --host_jvm_args=-Dbazel.DigestFunction=SHA1 --spawn_strategy=remote --remote_cache=localhost:%d build", *grpcPort)
-	}))
-
-	go func() {
-		logrus.Infof("listening for HTTP (debug) on: http://%v", httpListener.Addr().String())
-		http.Serve(httpListener, http.DefaultServeMux)
-	}()
-
-	logrus.Infof("listening for gRPC (bazel) on: %v", grpcListener.Addr().String())
-	if err := grpcServer.Serve(grpcListener); err != nil {
-		logrus.Fatalf("failed staring gRPC server: %v", err)
+	if err := daemon.Serve(grpcListener, httpListener, opts); err != nil {
+		logrus.Fatalf("%v", err)
 	}
 }
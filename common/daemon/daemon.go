@@ -0,0 +1,215 @@
+// Package daemon holds what the localcache and distcache binaries have in common: the flags configuring the cache
+// services, the gRPC server serving them from the stores selected by flags, and the HTTP debug interface.
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	_ "net/http/pprof" //registers "/debug/pprof"
+	"time"
+
+	remoteexecution_v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/mwitkow/bazel-distcache/common/sharedflags"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/common/writeback"
+	"github.com/mwitkow/bazel-distcache/proto/distcache/admin"
+	"github.com/mwitkow/bazel-distcache/proto/distcache/cas"
+	"github.com/mwitkow/bazel-distcache/service/actioncache"
+	"github.com/mwitkow/bazel-distcache/service/admin"
+	"github.com/mwitkow/bazel-distcache/service/capabilities"
+	"github.com/mwitkow/bazel-distcache/service/cas"
+	"github.com/mwitkow/bazel-distcache/service/httpcache"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"github.com/mwitkow/bazel-distcache/stores/gc"
+	_ "github.com/mwitkow/bazel-distcache/stores/remote" // registers the grpc:// blob store backend
+	_ "github.com/mwitkow/bazel-distcache/stores/s3"     // registers the s3:// store backends
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+	_ "golang.org/x/net/trace" // registers /debug/requests
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+)
+
+var (
+	grpcTracingEnabled = sharedflags.Set.Bool("grpc_tracing_enabled", false, "traces whole requests in /debug/request (expensive due to blobs)")
+
+	chunkSizeBytes = sharedflags.Set.Int("casservice_local_chunk_size_bytes",
+		2*1024*1024,
+		"Size of chunk streamed down to bazel clients. Can be max 4MB due to gRPC limits.")
+	uploadResumptionTimeout = sharedflags.Set.Duration("casservice_local_upload_resumption_timeout",
+		10*time.Minute,
+		"Time for which interrupted ByteStream uploads can be resumed before being discarded. Uploads can't be resumed if 0.")
+	batchReadMaxBytes = sharedflags.Set.Int64("casservice_local_batch_read_max_bytes",
+		3*1024*1024,
+		"Maximum total size of blobs read or updated in a single batch, advertised to clients in capabilities. Can be max 4MB due to gRPC limits.")
+
+	updateEnabled = sharedflags.Set.Bool("actioncache_update_enabled", true,
+		"Whether clients may store action results, advertised to clients in capabilities. If false, the cache is read-only for clients.")
+	deleteIncomplete = sharedflags.Set.Bool("actioncache_delete_incomplete", false,
+		"Whether action results referencing missing blobs are deleted from the store, rather than only treated as misses.")
+	failedResults = sharedflags.Set.String("actioncache_failed_results", actioncache.FailedResultsStore,
+		"What to do with results of actions with a non-zero exit code: 'store', 'reject' or 'expire' after actioncache_failed_results_ttl.")
+	failedResultsTTL = sharedflags.Set.Duration("actioncache_failed_results_ttl", 10*time.Minute,
+		"Time for which results of failed actions are served, with actioncache_failed_results=expire.")
+	failedResultsPath = sharedflags.Set.String("actioncache_failed_results_path", "/tmp/localcache-failed-results",
+		"Path for the ondisk records of when results of failed actions were stored, with actioncache_failed_results=expire.")
+	trustedNetworks = sharedflags.Set.StringSlice("actioncache_trusted_networks", []string{},
+		"CIDRs of clients allowed to store action results (e.g. CI agents). All clients are allowed if empty.")
+
+	writebackQueuePath = sharedflags.Set.String("writeback_queue_path", "/tmp/localcache-writeback",
+		"Path for the ondisk queue of writes pending replication to upstream.")
+	writebackConcurrency = sharedflags.Set.Int("writeback_concurrency", 4,
+		"Maximum number of concurrent uploads to upstream, per queue.")
+	writebackMaxBackoff = sharedflags.Set.Duration("writeback_max_backoff", 5*time.Minute,
+		"Maximum time between retries of a failed upload to upstream.")
+	writebackUploadTimeout = sharedflags.Set.Duration("writeback_upload_timeout", 10*time.Minute,
+		"Deadline of a single upload to upstream.")
+)
+
+// Options are what differs between the daemons.
+type Options struct {
+	// Name of the daemon, shown on the debug interface.
+	Name string
+	// AdminServiceEnabled serves the Admin service used by cacheadmin.
+	AdminServiceEnabled bool
+	// Upstream is the connection to the cache consulted on local misses, nil if there is none.
+	Upstream *grpc.ClientConn
+	// HTTPCacheListener serves bazel's HTTP cache protocol, if not nil.
+	HTTPCacheListener net.Listener
+	// DebugInfo writes daemon-specific lines to the index page of the debug interface, if not nil.
+	DebugInfo func(w io.Writer)
+}
+
+// Serve opens the stores selected by flags and serves the cache services on grpcListener and the debug interface on
+// httpListener. It returns when serving gRPC fails, or if the stores or services can't be initialised.
+func Serve(grpcListener net.Listener, httpListener net.Listener, opts Options) error {
+	if len(util.SupportedDigestFunctions()) == 0 {
+		return fmt.Errorf("no supported digest functions in --digest_functions")
+	}
+
+	logrusEntry := logrus.NewEntry(logrus.StandardLogger())
+	grpc_logrus.ReplaceGrpcLogger(logrusEntry)
+	grpcServer := grpc.NewServer(
+		grpc_middleware.WithUnaryServerChain(
+			grpc_prometheus.UnaryServerInterceptor,
+			grpc_logrus.UnaryServerInterceptor(logrusEntry),
+		),
+		grpc_middleware.WithStreamServerChain(
+			grpc_prometheus.StreamServerInterceptor,
+			grpc_logrus.StreamServerInterceptor(logrusEntry),
+		),
+	)
+	grpc.EnableTracing = *grpcTracingEnabled
+
+	blobBackend, err := blob.OpenBackendFromFlags()
+	if err != nil {
+		return fmt.Errorf("failed opening blob store: %v", err)
+	}
+	actionBackend, err := action.OpenBackendFromFlags()
+	if err != nil {
+		return fmt.Errorf("failed opening action store: %v", err)
+	}
+	blobStores := blob.NewPerInstanceForBackend(blobBackend)
+	actionStores := action.NewPerInstanceForBackend(actionBackend)
+	casInstance, err := cas.NewLocal(casConfigFromFlags(), blobStores, opts.Upstream)
+	if err != nil {
+		return err
+	}
+	defer casInstance.Close()
+	actionCacheInstance, err := actioncache.NewLocal(actionCacheConfigFromFlags(), actionStores, casInstance, opts.Upstream)
+	if err != nil {
+		return err
+	}
+	defer actionCacheInstance.Close()
+	gc.StartFromFlags(blobStores, actionStores)
+	remoteexecution.RegisterActionCacheServer(grpcServer, actionCacheInstance)
+	remoteexecution.RegisterContentAddressableStorageServer(grpcServer, casInstance)
+	bytestream.RegisterByteStreamServer(grpcServer, casInstance)
+	distcache_cas.RegisterContentAddressableStorageExtensionsServer(grpcServer, casInstance)
+	// REAPI v2 is served side by side with v1test from the same stores, ByteStream is shared by both.
+	remoteexecution_v2.RegisterActionCacheServer(grpcServer, actionCacheInstance.V2())
+	remoteexecution_v2.RegisterContentAddressableStorageServer(grpcServer, casInstance.V2())
+	remoteexecution_v2.RegisterCapabilitiesServer(grpcServer, capabilities.New(casInstance, actionCacheInstance))
+	if opts.AdminServiceEnabled {
+		distcache_admin.RegisterAdminServer(grpcServer, admin.New(blobStores, actionStores))
+	}
+
+	grpc_prometheus.Register(grpcServer)
+
+	if opts.HTTPCacheListener != nil {
+		go func() {
+			logrus.Infof("listening for HTTP (cache protocol) on: http://%v", opts.HTTPCacheListener.Addr().String())
+			if err := http.Serve(opts.HTTPCacheListener, httpcache.New(casInstance, actionCacheInstance)); err != nil {
+				logrus.Fatalf("failed serving HTTP cache protocol: %v", err)
+			}
+		}()
+	}
+
+	http.Handle("/metrics", prometheus.UninstrumentedHandler())
+	http.Handle("/", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("content-type", "text/plain")
+		resp.WriteHeader(http.StatusOK)
+		fmt.Fprintf(resp, "Debug interface of %v\n", opts.Name)
+		fmt.Fprintf(resp, "Supported digest functions: %v\n", DigestFunctionNames())
+		if opts.DebugInfo != nil {
+			opts.DebugInfo(resp)
+		}
+	}))
+
+	go func() {
+		logrus.Infof("listening for HTTP (debug) on: http://%v", httpListener.Addr().String())
+		http.Serve(httpListener, http.DefaultServeMux)
+	}()
+
+	logrus.Infof("listening for gRPC on: %v", grpcListener.Addr().String())
+	if err := grpcServer.Serve(grpcListener); err != nil {
+		return fmt.Errorf("failed staring gRPC server: %v", err)
+	}
+	return nil
+}
+
+func casConfigFromFlags() cas.Config {
+	return cas.Config{
+		ChunkSizeBytes:          *chunkSizeBytes,
+		UploadResumptionTimeout: *uploadResumptionTimeout,
+		BatchMaxBytes:           *batchReadMaxBytes,
+		Writeback:               writebackConfigFromFlags(),
+	}
+}
+
+func actionCacheConfigFromFlags() actioncache.Config {
+	return actioncache.Config{
+		UpdateEnabled:     *updateEnabled,
+		DeleteIncomplete:  *deleteIncomplete,
+		FailedResults:     *failedResults,
+		FailedResultsTTL:  *failedResultsTTL,
+		FailedResultsPath: *failedResultsPath,
+		TrustedNetworks:   *trustedNetworks,
+		Writeback:         writebackConfigFromFlags(),
+	}
+}
+
+func writebackConfigFromFlags() writeback.Config {
+	return writeback.Config{
+		Path:          *writebackQueuePath,
+		Concurrency:   *writebackConcurrency,
+		MaxBackoff:    *writebackMaxBackoff,
+		UploadTimeout: *writebackUploadTimeout,
+	}
+}
+
+// DigestFunctionNames returns the names of the digest functions enabled by flags.
+func DigestFunctionNames() []string {
+	var names []string
+	for _, f := range util.SupportedDigestFunctions() {
+		names = append(names, f.Name)
+	}
+	return names
+}
@@ -0,0 +1,45 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var byteSizeSuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	// Longest first, so that `B` doesn't shadow `KB`.
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseByteSize parses sizes like `2GB`, `512M` or `1000`, as used in store URLs. Suffixes are powers of 1024 and
+// case-insensitive.
+func ParseByteSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, u := range byteSizeSuffixes {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, u.suffix))
+			multiplier = u.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	if n > 0 && multiplier > (1<<63-1)/n {
+		return 0, fmt.Errorf("byte size %q is too large", s)
+	}
+	return n * multiplier, nil
+}
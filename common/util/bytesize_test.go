@@ -0,0 +1,37 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for _, tcase := range []struct {
+		input  string
+		isErr  bool
+		output int64
+	}{
+		{input: "0", output: 0},
+		{input: "1000", output: 1000},
+		{input: "100B", output: 100},
+		{input: "2GB", output: 2 << 30},
+		{input: "512m", output: 512 << 20},
+		{input: "3 KB", output: 3 << 10},
+		{input: "1T", output: 1 << 40},
+		{input: "", isErr: true},
+		{input: "GB", isErr: true},
+		{input: "-1", isErr: true},
+		{input: "1.5GB", isErr: true},
+		{input: "99999999999TB", isErr: true},
+	} {
+		t.Run(tcase.input, func(t *testing.T) {
+			out, err := ParseByteSize(tcase.input)
+			if tcase.isErr {
+				assert.Error(t, err, "should return an error")
+			} else {
+				assert.Equal(t, tcase.output, out, "should be equal")
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"crypto/rand"
 	"fmt"
 	"strconv"
 	"strings"
@@ -14,11 +15,37 @@ import (
 
 const (
 	digestFilenameVersion = 1
-	blobsResourceField    = "blobs/"
+	blobsResourceField    = "blobs"
+	uploadsResourceField  = "uploads"
 )
 
-func ContentDigestToBase64(digest *remoteexecution.Digest) string {
-	return fmt.Sprintf("v%d_%s", digestFilenameVersion, digest.Hash)
+// ContentDigestToKey returns the key under which the content of the digest is stored.
+// Keys of digests of different digest functions never collide. Returns an InvalidArgument error if the digest isn't
+// valid, so keys are safe to use as file names.
+func ContentDigestToKey(digest *remoteexecution.Digest) (string, error) {
+	digestFunction, err := DigestFunctionForHash(digest.Hash)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("v%d_%s%s", digestFilenameVersion, digestFunction.keyPrefix, digest.Hash), nil
+}
+
+// KeyToContentDigest is the reverse of ContentDigestToKey, for listing stored content. The size isn't part of the key,
+// so it needs to be passed in. Returns an InvalidArgument error if the key isn't one of a digest.
+func KeyToContentDigest(key string, sizeBytes int64) (*remoteexecution.Digest, error) {
+	versionPrefix := fmt.Sprintf("v%d_", digestFilenameVersion)
+	if !strings.HasPrefix(key, versionPrefix) {
+		return nil, status.Errorf(codes.InvalidArgument, "%q is not a content digest key", key)
+	}
+	key = strings.TrimPrefix(key, versionPrefix)
+	// Functions with a key prefix are matched first, as SHA1 doesn't have one.
+	for _, f := range allDigestFunctions {
+		hash := strings.TrimPrefix(key, f.keyPrefix)
+		if strings.HasPrefix(key, f.keyPrefix) && len(hash) == f.hexLength && isLowerHex(hash) {
+			return &remoteexecution.Digest{Hash: hash, SizeBytes: sizeBytes}, nil
+		}
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "%q is not a content digest key", key)
 }
 
 // ResourceToContentDigest translates the bytestream resource name into a Digest object.
@@ -28,23 +55,84 @@ func ContentDigestToBase64(digest *remoteexecution.Digest) string {
 //  * {instance_name}/uploads/{uuid}/blobs/{hash}/{size}/foo/bar/baz.cc
 //  * {instance_name}/blobs/{hash}/{size}
 func ResourcePathToContentDigest(resourceName string) (*remoteexecution.Digest, error) {
-	var err error
-	blobsOffset := strings.Index(resourceName, blobsResourceField)
-	if blobsOffset == -1 {
-		return nil, status.Errorf(codes.InvalidArgument, "bytestream resource must contain 'blobs/'")
+	_, _, digest, err := splitResourcePath(resourceName)
+	return digest, err
+}
+
+// ContentDigestToUploadResourcePath builds a fresh bytestream resource name for uploading the blob of the digest.
+//
+// See `resource_name` in the documentation of `ContentAddressableStorage`.
+//  * {instance_name}/uploads/{uuid}/blobs/{hash}/{size}
+func ContentDigestToUploadResourcePath(instanceName string, digest *remoteexecution.Digest) string {
+	uuid := make([]byte, 16)
+	rand.Read(uuid)
+	uuid[6] = (uuid[6] & 0x0f) | 0x40 // version 4
+	uuid[8] = (uuid[8] & 0x3f) | 0x80 // variant 10
+	resourceName := fmt.Sprintf("uploads/%x-%x-%x-%x-%x/blobs/%s/%d",
+		uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:], digest.Hash, digest.SizeBytes)
+	if instanceName != "" {
+		resourceName = instanceName + "/" + resourceName
+	}
+	return resourceName
+}
+
+// ContentDigestToResourcePath builds the bytestream resource name for reading the blob of the digest.
+//
+// See `resource_name` in the documentation of `ContentAddressableStorage`.
+//  * {instance_name}/blobs/{hash}/{size}
+func ContentDigestToResourcePath(instanceName string, digest *remoteexecution.Digest) string {
+	resourceName := fmt.Sprintf("blobs/%s/%d", digest.Hash, digest.SizeBytes)
+	if instanceName != "" {
+		resourceName = instanceName + "/" + resourceName
 	}
+	return resourceName
+}
 
-	parts := strings.SplitN(resourceName[blobsOffset:], "/", 4)
-	if len(parts) < 3 {
-		return nil, status.Errorf(codes.InvalidArgument, "bytestream resource doesn't have enough parts")
+// ResourcePathToInstanceName returns the instance name prefix of a bytestream resource name, empty if there is none.
+func ResourcePathToInstanceName(resourceName string) string {
+	instanceName, _, _, err := splitResourcePath(resourceName)
+	if err != nil {
+		return ""
 	}
-	ret := &remoteexecution.Digest{}
-	ret.Hash = parts[1]
-	ret.SizeBytes, err = strconv.ParseInt(parts[2], 10, 64)
+	return instanceName
+}
+
+// ResourcePathToUploadID returns the `{uuid}` of a bytestream resource name of an upload, empty if there is none.
+func ResourcePathToUploadID(resourceName string) string {
+	_, uploadID, _, err := splitResourcePath(resourceName)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "bytestream size can't be parsed: %v", err)
+		return ""
+	}
+	return uploadID
+}
+
+// splitResourcePath splits a bytestream resource name into its instance name, upload uuid and digest.
+// The fields are matched as whole path segments, at the first `blobs/{hash}/{size}`, so that instance names like
+// `ciblobs` or `team/uploads` aren't mistaken for them.
+func splitResourcePath(resourceName string) (string, string, *remoteexecution.Digest, error) {
+	parts := strings.Split(resourceName, "/")
+	var sizeErr error
+	for i := 0; i+2 < len(parts); i++ {
+		if parts[i] != blobsResourceField {
+			continue
+		}
+		size, err := strconv.ParseInt(parts[i+2], 10, 64)
+		if err != nil {
+			sizeErr = err
+			continue
+		}
+		instanceParts := parts[:i]
+		uploadID := ""
+		if n := len(instanceParts); n >= 2 && instanceParts[n-2] == uploadsResourceField {
+			uploadID = instanceParts[n-1]
+			instanceParts = instanceParts[:n-2]
+		}
+		return strings.Join(instanceParts, "/"), uploadID, &remoteexecution.Digest{Hash: parts[i+1], SizeBytes: size}, nil
+	}
+	if sizeErr != nil {
+		return "", "", nil, status.Errorf(codes.InvalidArgument, "bytestream size can't be parsed: %v", sizeErr)
 	}
-	return ret, nil
+	return "", "", nil, status.Errorf(codes.InvalidArgument, "bytestream resource must contain 'blobs/{hash}/{size}'")
 }
 
 func traceFromCtx(ctx context.Context) trace.Trace {
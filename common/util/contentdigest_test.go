@@ -1,6 +1,7 @@
 package util
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,10 +34,22 @@ func TestResourcePathToContentDigest(t *testing.T) {
 			input:  "blobs/A0F4BBBB11114444/123456789",
 			output: &remoteexecution.Digest{Hash: "A0F4BBBB11114444", SizeBytes: 123456789},
 		},
+		{
+			input:  "ciblobs/blobs/A0F4BBBB11114444/123456789",
+			output: &remoteexecution.Digest{Hash: "A0F4BBBB11114444", SizeBytes: 123456789},
+		},
+		{
+			input:  "team/uploads/uploads/some-uuid/blobs/A0F4BBBB11114444/123456789",
+			output: &remoteexecution.Digest{Hash: "A0F4BBBB11114444", SizeBytes: 123456789},
+		},
 		{
 			input: "blob/A0F4BBBB11114444/123456789",
 			isErr: true,
 		},
+		{
+			input: "ciblobs/A0F4BBBB11114444/123456789",
+			isErr: true,
+		},
 		{
 			input: "blobs/A0F4BBBB11114444/asda",
 			isErr: true,
@@ -54,3 +67,73 @@ func TestResourcePathToContentDigest(t *testing.T) {
 		})
 	}
 }
+
+func TestContentDigestToUploadResourcePath_RoundTrips(t *testing.T) {
+	digest := &remoteexecution.Digest{Hash: "A0F4BBBB11114444", SizeBytes: 123456789}
+	for _, instanceName := range []string{"", "with_instance"} {
+		t.Run(instanceName, func(t *testing.T) {
+			resourceName := ContentDigestToUploadResourcePath(instanceName, digest)
+			assert.True(t, strings.HasPrefix(resourceName, instanceName), "should start with the instance name")
+			out, err := ResourcePathToContentDigest(resourceName)
+			assert.NoError(t, err, "should parse")
+			assert.EqualValues(t, digest, out, "should be equal in values")
+		})
+	}
+	assert.NotEqual(t, ContentDigestToUploadResourcePath("", digest), ContentDigestToUploadResourcePath("", digest),
+		"each upload should get its own uuid")
+}
+
+func TestResourcePathToInstanceName(t *testing.T) {
+	for _, tcase := range []struct {
+		input  string
+		output string
+	}{
+		{input: "with_instance/blobs/A0F4BBBB11114444/123456789", output: "with_instance"},
+		{input: "with/nested/instance/blobs/A0F4BBBB11114444/123456789", output: "with/nested/instance"},
+		{input: "with_instance/uploads/some-uuid/blobs/A0F4BBBB11114444/123456789/uploads/foo", output: "with_instance"},
+		{input: "uploads/some-uuid/blobs/A0F4BBBB11114444/123456789", output: ""},
+		{input: "blobs/A0F4BBBB11114444/123456789", output: ""},
+		{input: "ciblobs/blobs/A0F4BBBB11114444/123456789", output: "ciblobs"},
+		{input: "ciuploads/uploads/some-uuid/blobs/A0F4BBBB11114444/123456789", output: "ciuploads"},
+		{input: "team/uploads/blobs/A0F4BBBB11114444/123456789", output: "team/uploads"},
+		{input: "team/uploads/uploads/some-uuid/blobs/A0F4BBBB11114444/123456789", output: "team/uploads"},
+	} {
+		t.Run(tcase.input, func(t *testing.T) {
+			assert.Equal(t, tcase.output, ResourcePathToInstanceName(tcase.input), "should be equal")
+		})
+	}
+}
+
+func TestResourcePathToUploadID(t *testing.T) {
+	for _, tcase := range []struct {
+		input  string
+		output string
+	}{
+		{input: "with_instance/uploads/some-uuid/blobs/A0F4BBBB11114444/123456789/uploads/foo", output: "some-uuid"},
+		{input: "uploads/some-uuid/blobs/A0F4BBBB11114444/123456789", output: "some-uuid"},
+		{input: "blobs/A0F4BBBB11114444/123456789", output: ""},
+		{input: "team/uploads/blobs/A0F4BBBB11114444/123456789", output: ""},
+		{input: "uploads/some-uuid/blobs/A0F4BBBB11114444/size", output: ""},
+	} {
+		t.Run(tcase.input, func(t *testing.T) {
+			assert.Equal(t, tcase.output, ResourcePathToUploadID(tcase.input), "should be equal")
+		})
+	}
+}
+
+func TestKeyToContentDigest_RoundTrips(t *testing.T) {
+	for _, digest := range []*remoteexecution.Digest{
+		{Hash: "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed", SizeBytes: 11},
+		{Hash: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", SizeBytes: 11},
+	} {
+		key, err := ContentDigestToKey(digest)
+		assert.NoError(t, err, "should make a key")
+		out, err := KeyToContentDigest(key, digest.SizeBytes)
+		assert.NoError(t, err, "should parse the key")
+		assert.EqualValues(t, digest, out, "should be equal in values")
+	}
+	for _, key := range []string{".staging-v1_2aae6c35c94fcfb415dbe95f408b9ce91ee846ed-123", "v1_2aae6c35", "instances"} {
+		_, err := KeyToContentDigest(key, 0)
+		assert.Error(t, err, "%q is not a key", key)
+	}
+}
@@ -0,0 +1,129 @@
+package util
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+
+	"github.com/mwitkow/bazel-distcache/common/sharedflags"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DigestFunction is a hash function used for content digests.
+// Digests don't say which function they use, so it is told apart by the length of the hash.
+type DigestFunction struct {
+	// Name is the name of the function, as in bazel's `-Dbazel.DigestFunction`.
+	Name string
+	// keyPrefix distinguishes storage keys of digests of different functions.
+	keyPrefix string
+	hexLength int
+	newHash   func() hash.Hash
+}
+
+var (
+	SHA1   = &DigestFunction{Name: "SHA1", keyPrefix: "", hexLength: 2 * sha1.Size, newHash: sha1.New}
+	SHA256 = &DigestFunction{Name: "SHA256", keyPrefix: "sha256_", hexLength: 2 * sha256.Size, newHash: sha256.New}
+
+	allDigestFunctions = []*DigestFunction{SHA256, SHA1}
+
+	enabledDigestFunctions = sharedflags.Set.StringSlice("digest_functions", []string{SHA256.Name, SHA1.Name},
+		"Digest functions accepted from clients, most preferred first.")
+)
+
+// NewHash returns a fresh hash of the function.
+func (f *DigestFunction) NewHash() hash.Hash {
+	return f.newHash()
+}
+
+// SupportedDigestFunctions returns the digest functions enabled by flags, most preferred first.
+func SupportedDigestFunctions() []*DigestFunction {
+	var ret []*DigestFunction
+	for _, name := range *enabledDigestFunctions {
+		for _, f := range allDigestFunctions {
+			if f.Name == name {
+				ret = append(ret, f)
+			}
+		}
+	}
+	return ret
+}
+
+// DigestFunctionForHash returns the supported digest function that the hash was computed with.
+// Returns an InvalidArgument error if the hash isn't lowercase hex of a supported function.
+func DigestFunctionForHash(hash string) (*DigestFunction, error) {
+	if !isLowerHex(hash) {
+		return nil, status.Errorf(codes.InvalidArgument, "digest hash %q is not lowercase hex", hash)
+	}
+	for _, f := range SupportedDigestFunctions() {
+		if len(hash) == f.hexLength {
+			return f, nil
+		}
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "digest hash %q is not of a supported digest function", hash)
+}
+
+func isLowerHex(hash string) bool {
+	for _, c := range hash {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ContentVerifier computes the content digest of the data written into it, so that streamed data can be checked
+// against the digest it claims to have.
+type ContentVerifier struct {
+	hash hash.Hash
+	size int64
+}
+
+// NewContentVerifier returns a ContentVerifier for the digest function with nothing written into it.
+func NewContentVerifier(digestFunction *DigestFunction) *ContentVerifier {
+	return &ContentVerifier{hash: digestFunction.NewHash()}
+}
+
+// Write adds data to the digest computation. It never returns an error.
+func (v *ContentVerifier) Write(p []byte) (int, error) {
+	v.hash.Write(p)
+	v.size += int64(len(p))
+	return len(p), nil
+}
+
+// Digest returns the content digest of the data written so far.
+func (v *ContentVerifier) Digest() *remoteexecution.Digest {
+	return &remoteexecution.Digest{Hash: hex.EncodeToString(v.hash.Sum(nil)), SizeBytes: v.size}
+}
+
+// Verify checks that the data written so far matches the digest, returning an InvalidArgument error if it doesn't.
+func (v *ContentVerifier) Verify(digest *remoteexecution.Digest) error {
+	actual := v.Digest()
+	if actual.SizeBytes != digest.SizeBytes {
+		return status.Errorf(codes.InvalidArgument, "blob size %d doesn't match digest size %d", actual.SizeBytes, digest.SizeBytes)
+	}
+	if actual.Hash != digest.Hash {
+		return status.Errorf(codes.InvalidArgument, "blob hash %v doesn't match digest hash %v", actual.Hash, digest.Hash)
+	}
+	return nil
+}
+
+// DataToContentDigest computes the content digest of the data with the digest function.
+func DataToContentDigest(digestFunction *DigestFunction, data []byte) *remoteexecution.Digest {
+	v := NewContentVerifier(digestFunction)
+	v.Write(data)
+	return v.Digest()
+}
+
+// VerifyContentDigest checks that the data matches the digest, returning an InvalidArgument error if it doesn't.
+func VerifyContentDigest(digest *remoteexecution.Digest, data []byte) error {
+	digestFunction, err := DigestFunctionForHash(digest.Hash)
+	if err != nil {
+		return err
+	}
+	v := NewContentVerifier(digestFunction)
+	v.Write(data)
+	return v.Verify(digest)
+}
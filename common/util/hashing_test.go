@@ -0,0 +1,88 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVerifyContentDigest(t *testing.T) {
+	data := []byte("hello world")
+	for _, tcase := range []struct {
+		name   string
+		digest *remoteexecution.Digest
+		code   codes.Code
+	}{
+		{
+			name:   "matching",
+			digest: &remoteexecution.Digest{Hash: "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed", SizeBytes: 11},
+			code:   codes.OK,
+		},
+		{
+			name:   "bad_size",
+			digest: &remoteexecution.Digest{Hash: "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed", SizeBytes: 12},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "matching_sha256",
+			digest: &remoteexecution.Digest{Hash: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", SizeBytes: 11},
+			code:   codes.OK,
+		},
+		{
+			name:   "bad_hash",
+			digest: &remoteexecution.Digest{Hash: "0aae6c35c94fcfb415dbe95f408b9ce91ee846ed", SizeBytes: 11},
+			code:   codes.InvalidArgument,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			assert.Equal(t, tcase.code, status.Code(VerifyContentDigest(tcase.digest, data)), "should return expected code")
+		})
+	}
+}
+
+func TestContentDigestToKey(t *testing.T) {
+	for _, tcase := range []struct {
+		name   string
+		digest *remoteexecution.Digest
+		isErr  bool
+		output string
+	}{
+		{
+			name:   "sha1",
+			digest: DataToContentDigest(SHA1, []byte("hello world")),
+			output: "v1_2aae6c35c94fcfb415dbe95f408b9ce91ee846ed",
+		},
+		{
+			name:   "sha256",
+			digest: DataToContentDigest(SHA256, []byte("hello world")),
+			output: "v1_sha256_b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+		},
+		{
+			name:   "uppercase",
+			digest: &remoteexecution.Digest{Hash: "2AAE6C35C94FCFB415DBE95F408B9CE91EE846ED", SizeBytes: 11},
+			isErr:  true,
+		},
+		{
+			name:   "unknown_length",
+			digest: &remoteexecution.Digest{Hash: "2aae6c35", SizeBytes: 11},
+			isErr:  true,
+		},
+		{
+			name:   "path_traversal",
+			digest: &remoteexecution.Digest{Hash: "../../../../../../../../../../../../../etc/passwd", SizeBytes: 11},
+			isErr:  true,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			out, err := ContentDigestToKey(tcase.digest)
+			if tcase.isErr {
+				assert.Equal(t, codes.InvalidArgument, status.Code(err), "should return InvalidArgument")
+			} else {
+				assert.Equal(t, tcase.output, out, "should be equal")
+			}
+		})
+	}
+}
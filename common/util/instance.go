@@ -0,0 +1,71 @@
+package util
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	instancesDir = "instances"
+	// StagingPrefix marks the files of writes in progress in the directories of the ondisk stores. They are renamed to
+	// the key of what they hold once written, and left behind ones are removed on startup.
+	StagingPrefix = ".staging-"
+)
+
+// InstanceNameToPath returns the directory, relative to a store's base path, that holds the data of the instance.
+// The default (empty) instance is stored directly in the base path, others under `instances/{instance_name}`, so that
+// they can be wiped independently.
+func InstanceNameToPath(instanceName string) (string, error) {
+	if instanceName == "" {
+		return "", nil
+	}
+	for _, segment := range strings.Split(instanceName, "/") {
+		if segment == "" || strings.HasPrefix(segment, ".") {
+			return "", status.Errorf(codes.InvalidArgument, "instance name %q is invalid", instanceName)
+		}
+	}
+	return path.Join(instancesDir, instanceName), nil
+}
+
+// InstanceNamesInPath returns the names of instances that may have data stored under the base path of a store: the
+// default instance, and one for each directory under `instances`. Directories of nested instance names (e.g. `a/b`)
+// also show up as their parents (`a`), which are harmless empty instances if never used.
+func InstanceNamesInPath(basePath string) ([]string, error) {
+	names := []string{""}
+	instancesPath := path.Join(basePath, instancesDir)
+	err := filepath.Walk(instancesPath, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && walkPath == instancesPath {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() || walkPath == instancesPath {
+			return nil
+		}
+		name, err := filepath.Rel(instancesPath, walkPath)
+		if err != nil {
+			return err
+		}
+		if _, err := InstanceNameToPath(filepath.ToSlash(name)); err != nil {
+			// Not created by a store, e.g. a hidden directory.
+			return filepath.SkipDir
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "can't list instances in %v: %v", basePath, err)
+	}
+	return names, nil
+}
+
+// IsStagingFile returns whether the file in the directory of an ondisk store is one of a write in progress.
+func IsStagingFile(fileName string) bool {
+	return strings.HasPrefix(fileName, StagingPrefix)
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceNameToPath(t *testing.T) {
+	for _, tcase := range []struct {
+		input  string
+		isErr  bool
+		output string
+	}{
+		{input: "", output: ""},
+		{input: "release", output: "instances/release"},
+		{input: "projects/foo/instances/dev", output: "instances/projects/foo/instances/dev"},
+		{input: "../etc", isErr: true},
+		{input: "/absolute", isErr: true},
+		{input: "trailing/", isErr: true},
+		{input: "foo/.staging-bar", isErr: true},
+	} {
+		t.Run(tcase.input, func(t *testing.T) {
+			out, err := InstanceNameToPath(tcase.input)
+			if tcase.isErr {
+				assert.Error(t, err, "should return an error")
+			} else {
+				assert.Equal(t, tcase.output, out, "should be equal")
+			}
+		})
+	}
+}
+
+func TestInstanceNamesInPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "instance_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	names, err := InstanceNamesInPath(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{""}, names, "only the default instance should exist without instances")
+
+	for _, name := range []string{"release", "projects/dev"} {
+		instancePath, err := InstanceNameToPath(name)
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(path.Join(dir, instancePath), 0777))
+	}
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "instances", "release", "v1_file"), []byte{}, 0666))
+	names, err = InstanceNamesInPath(dir)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"", "release", "projects", "projects/dev"}, names)
+}
@@ -0,0 +1,76 @@
+package util
+
+import (
+	remoteexecution_v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Stores keep the v1test messages, the REAPI v2 front-ends convert to and from them.
+
+// DigestFromV2 converts a REAPI v2 digest to the v1test one used by stores.
+func DigestFromV2(digest *remoteexecution_v2.Digest) *remoteexecution.Digest {
+	if digest == nil {
+		return nil
+	}
+	return &remoteexecution.Digest{Hash: digest.Hash, SizeBytes: digest.SizeBytes}
+}
+
+// DigestToV2 converts a v1test digest used by stores to the REAPI v2 one.
+func DigestToV2(digest *remoteexecution.Digest) *remoteexecution_v2.Digest {
+	if digest == nil {
+		return nil
+	}
+	return &remoteexecution_v2.Digest{Hash: digest.Hash, SizeBytes: digest.SizeBytes}
+}
+
+// DigestsFromV2 converts a list of REAPI v2 digests to v1test ones.
+func DigestsFromV2(digests []*remoteexecution_v2.Digest) []*remoteexecution.Digest {
+	var ret []*remoteexecution.Digest
+	for _, d := range digests {
+		ret = append(ret, DigestFromV2(d))
+	}
+	return ret
+}
+
+// DigestsToV2 converts a list of v1test digests to REAPI v2 ones.
+func DigestsToV2(digests []*remoteexecution.Digest) []*remoteexecution_v2.Digest {
+	var ret []*remoteexecution_v2.Digest
+	for _, d := range digests {
+		ret = append(ret, DigestToV2(d))
+	}
+	return ret
+}
+
+// ActionResultFromV2 converts a REAPI v2 action result to the v1test one used by stores.
+// The messages are wire compatible, and fields that only exist in v2 (e.g. symlinks and execution metadata) are
+// kept as unknown fields, so they survive a round trip through the stores.
+func ActionResultFromV2(result *remoteexecution_v2.ActionResult) (*remoteexecution.ActionResult, error) {
+	ret := &remoteexecution.ActionResult{}
+	if err := convertMessage(result, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ActionResultToV2 converts a v1test action result used by stores to the REAPI v2 one.
+func ActionResultToV2(result *remoteexecution.ActionResult) (*remoteexecution_v2.ActionResult, error) {
+	ret := &remoteexecution_v2.ActionResult{}
+	if err := convertMessage(result, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func convertMessage(from proto.Message, to proto.Message) error {
+	data, err := proto.Marshal(from)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot marshal %T: %v", from, err)
+	}
+	if err := proto.Unmarshal(data, to); err != nil {
+		return status.Errorf(codes.Internal, "cannot convert %T to %T: %v", from, to, err)
+	}
+	return nil
+}
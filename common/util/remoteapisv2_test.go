@@ -0,0 +1,36 @@
+package util
+
+import (
+	"testing"
+
+	remoteexecution_v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActionResultV2RoundTrip(t *testing.T) {
+	digest := &remoteexecution_v2.Digest{Hash: "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed", SizeBytes: 11}
+	v2Result := &remoteexecution_v2.ActionResult{
+		OutputFiles: []*remoteexecution_v2.OutputFile{{Path: "out/hello", Digest: digest, IsExecutable: true}},
+		// Symlinks and execution metadata don't exist in v1test.
+		OutputFileSymlinks: []*remoteexecution_v2.OutputSymlink{{Path: "out/link", Target: "hello"}},
+		ExecutionMetadata:  &remoteexecution_v2.ExecutedActionMetadata{Worker: "worker-1"},
+		ExitCode:           0,
+		StdoutDigest:       digest,
+	}
+	v1Result, err := ActionResultFromV2(v2Result)
+	require.NoError(t, err)
+	assert.Equal(t, "out/hello", v1Result.OutputFiles[0].Path)
+	assert.Equal(t, DigestFromV2(digest), v1Result.StdoutDigest)
+
+	// Stores serialize the v1test message, so do the same here.
+	stored, err := proto.Marshal(v1Result)
+	require.NoError(t, err)
+	v1Result.Reset()
+	require.NoError(t, proto.Unmarshal(stored, v1Result))
+
+	roundTripped, err := ActionResultToV2(v1Result)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(v2Result, roundTripped), "expected %v, got %v", v2Result, roundTripped)
+}
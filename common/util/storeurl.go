@@ -0,0 +1,27 @@
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// CheckURLQuery fails if the store URL has query parameters other than the known ones, as they're likely typos that
+// would otherwise silently leave a store unbounded.
+func CheckURLQuery(storeURL *url.URL, known ...string) error {
+	knownSet := make(map[string]bool, len(known))
+	for _, k := range known {
+		knownSet[k] = true
+	}
+	var unknown []string
+	for k := range storeURL.Query() {
+		if !knownSet[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown parameters %v in store URL %q, known: %v", unknown, storeURL.String(), known)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package writeback
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tmpFilePrefix = ".tmp-"
+	minBackoff    = 1 * time.Second
+)
+
+var (
+	pendingGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "distcache",
+			Subsystem: "writeback",
+			Name:      "pending_entries",
+			Help:      "Number of entries waiting to be replicated to upstream.",
+		}, []string{"queue"})
+	uploadsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "distcache",
+			Subsystem: "writeback",
+			Name:      "uploads_total",
+			Help:      "Number of upload attempts to upstream, by result.",
+		}, []string{"queue", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(pendingGauge, uploadsCounter)
+}
+
+// Config configures the Queues of a daemon.
+type Config struct {
+	// Path of the directory the queues are persisted in, each in its own subdirectory.
+	Path string
+	// Concurrency is the maximum number of concurrent uploads to upstream, per queue.
+	Concurrency int
+	// MaxBackoff is the maximum time between retries of a failed upload.
+	MaxBackoff time.Duration
+	// UploadTimeout is the deadline of a single upload.
+	UploadTimeout time.Duration
+}
+
+// Handler replicates the locally stored entry for the digest to upstream.
+// Errors with codes.NotFound, codes.InvalidArgument, codes.FailedPrecondition or codes.PermissionDenied (e.g. the
+// upstream policy rejecting a failed or untrusted action result) are considered permanent and the entry is dropped, all
+// other errors are retried with backoff.
+type Handler func(ctx context.Context, instanceName string, digest *remoteexecution.Digest) error
+
+// Queue is a durable queue of digests that need to be replicated to upstream.
+// Entries are persisted on disk before Enqueue returns and are only removed once the Handler succeeds, so pending
+// uploads survive restarts.
+type Queue struct {
+	name          string
+	dir           string
+	handler       Handler
+	maxBackoff    time.Duration
+	uploadTimeout time.Duration
+
+	mu       sync.Mutex
+	pending  []string
+	queued   map[string]bool // keys that are either pending, in flight or waiting for a retry
+	inFlight map[string]bool
+	dirty    map[string]bool // keys enqueued again while in flight, uploaded once more when done
+	attempts map[string]int
+	wakeup   chan struct{}
+}
+
+type entry struct {
+	InstanceName string `json:"instance_name"`
+	Hash         string `json:"hash"`
+	SizeBytes    int64  `json:"size_bytes"`
+}
+
+// New constructs a Queue persisted in a subdirectory of the configured path, and starts its upload workers.
+func New(cfg Config, name string, handler Handler) (*Queue, error) {
+	return newQueue(name, path.Join(cfg.Path, name), cfg.Concurrency, cfg.MaxBackoff, cfg.UploadTimeout, handler)
+}
+
+func newQueue(name string, dir string, concurrency int, maxBackoff time.Duration, uploadTimeout time.Duration, handler Handler) (*Queue, error) {
+	q := &Queue{
+		name:          name,
+		dir:           dir,
+		handler:       handler,
+		maxBackoff:    maxBackoff,
+		uploadTimeout: uploadTimeout,
+		queued:        make(map[string]bool),
+		inFlight:      make(map[string]bool),
+		dirty:         make(map[string]bool),
+		attempts:      make(map[string]int),
+		wakeup:        make(chan struct{}, 1),
+	}
+	if err := q.init(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < concurrency; i++ {
+		go q.worker()
+	}
+	return q, nil
+}
+
+// init picks up entries left over by a previous run, oldest first.
+func (q *Queue) init() error {
+	if err := os.MkdirAll(q.dir, 0777); err != nil {
+		return fmt.Errorf("writeback queue %v initialization error: %v", q.name, err)
+	}
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return fmt.Errorf("writeback queue %v initialization error: %v", q.name, err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), tmpFilePrefix) {
+			os.Remove(path.Join(q.dir, f.Name()))
+			continue
+		}
+		q.push(f.Name())
+	}
+	if len(q.pending) > 0 {
+		log.Infof("writeback queue %v: resuming %d pending uploads", q.name, len(q.pending))
+	}
+	return nil
+}
+
+// Enqueue durably records that the digest needs replicating upstream.
+// Enqueuing a digest that is already pending is a no-op. If its upload is already in flight, it may have read the entry
+// before it was stored again, so the digest is uploaded once more after it.
+func (q *Queue) Enqueue(instanceName string, digest *remoteexecution.Digest) error {
+	key, err := util.ContentDigestToKey(digest)
+	if err != nil {
+		return err
+	}
+	if instanceName != "" {
+		// The same digest in different instances needs replicating separately.
+		key = url.QueryEscape(instanceName) + "_" + key
+	}
+	q.mu.Lock()
+	alreadyQueued := q.queued[key]
+	if alreadyQueued && q.inFlight[key] {
+		q.dirty[key] = true
+	}
+	q.mu.Unlock()
+	if alreadyQueued {
+		return nil
+	}
+	content, err := json.Marshal(&entry{InstanceName: instanceName, Hash: digest.Hash, SizeBytes: digest.SizeBytes})
+	if err != nil {
+		return status.Errorf(codes.Internal, "writeback queue can't marshal entry: %v", err)
+	}
+	tmpFile, err := ioutil.TempFile(q.dir, tmpFilePrefix)
+	if err != nil {
+		return status.Errorf(codes.Internal, "writeback queue can't create file: %v", err)
+	}
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path.Join(q.dir, key))
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return status.Errorf(codes.Internal, "writeback queue can't write file: %v", err)
+	}
+	q.push(key)
+	return nil
+}
+
+func (q *Queue) push(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queued[key] {
+		return
+	}
+	q.queued[key] = true
+	q.pending = append(q.pending, key)
+	pendingGauge.WithLabelValues(q.name).Inc()
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// pop blocks until there's a pending key to process.
+func (q *Queue) pop() string {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			key := q.pending[0]
+			q.pending = q.pending[1:]
+			q.inFlight[key] = true
+			more := len(q.pending) > 0
+			q.mu.Unlock()
+			if more {
+				// Make sure other idle workers pick up the remaining entries.
+				select {
+				case q.wakeup <- struct{}{}:
+				default:
+				}
+			}
+			return key
+		}
+		q.mu.Unlock()
+		<-q.wakeup
+	}
+}
+
+func (q *Queue) worker() {
+	for {
+		key := q.pop()
+		q.process(key)
+	}
+}
+
+func (q *Queue) process(key string) {
+	fileName := path.Join(q.dir, key)
+	e, err := readEntry(fileName)
+	if err != nil {
+		log.WithError(err).Errorf("writeback queue %v: dropping unreadable entry %v", q.name, key)
+		q.done(key, fileName)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), q.uploadTimeout)
+	err = q.handler(ctx, e.InstanceName, &remoteexecution.Digest{Hash: e.Hash, SizeBytes: e.SizeBytes})
+	cancel()
+	if err == nil {
+		uploadsCounter.WithLabelValues(q.name, "ok").Inc()
+		q.done(key, fileName)
+		return
+	}
+	if isPermanent(err) {
+		uploadsCounter.WithLabelValues(q.name, "dropped").Inc()
+		log.WithError(err).Warnf("writeback queue %v: dropping %v after permanent error", q.name, key)
+		q.done(key, fileName)
+		return
+	}
+	uploadsCounter.WithLabelValues(q.name, "retried").Inc()
+	q.mu.Lock()
+	// The retry reads the entry again anyway.
+	delete(q.inFlight, key)
+	delete(q.dirty, key)
+	q.mu.Unlock()
+	backoff := q.backoff(key)
+	log.WithError(err).Warnf("writeback queue %v: upload of %v failed, retrying in %v", q.name, key, backoff)
+	time.AfterFunc(backoff, func() { q.retry(key) })
+}
+
+func isPermanent(err error) bool {
+	switch status.Code(err) {
+	case codes.NotFound, codes.InvalidArgument, codes.FailedPrecondition, codes.PermissionDenied:
+		return true
+	}
+	return false
+}
+
+// backoff returns the exponential, jittered delay before the next attempt of key.
+func (q *Queue) backoff(key string) time.Duration {
+	q.mu.Lock()
+	attempt := q.attempts[key]
+	q.attempts[key] = attempt + 1
+	q.mu.Unlock()
+	backoff := q.maxBackoff
+	if attempt < 32 && minBackoff<<uint(attempt) < q.maxBackoff {
+		backoff = minBackoff << uint(attempt)
+	}
+	// Jitter of +/- 20% so that a recovering upstream doesn't get all the retries at once.
+	return time.Duration(float64(backoff) * (0.8 + 0.4*rand.Float64()))
+}
+
+func (q *Queue) retry(key string) {
+	q.mu.Lock()
+	q.pending = append(q.pending, key)
+	q.mu.Unlock()
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// done removes the entry of a finished upload, or queues it once more if it was enqueued again in the meantime.
+func (q *Queue) done(key string, fileName string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inFlight, key)
+	delete(q.attempts, key)
+	if q.dirty[key] {
+		delete(q.dirty, key)
+		q.pending = append(q.pending, key)
+		select {
+		case q.wakeup <- struct{}{}:
+		default:
+		}
+		return
+	}
+	// The file is removed under the lock, so that a concurrent Enqueue either marks the key dirty or writes a new file.
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Errorf("writeback queue %v: failed removing entry %v", q.name, key)
+	}
+	delete(q.queued, key)
+	pendingGauge.WithLabelValues(q.name).Dec()
+}
+
+func readEntry(fileName string) (*entry, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	e := &entry{}
+	if err := json.Unmarshal(content, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,113 @@
+package writeback
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var testDigest = &remoteexecution.Digest{Hash: "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed", SizeBytes: 11}
+
+func handlerInto(calls chan *remoteexecution.Digest, err error) Handler {
+	return func(ctx context.Context, instanceName string, digest *remoteexecution.Digest) error {
+		calls <- digest
+		return err
+	}
+}
+
+func waitForEmptyDir(t *testing.T, dir string) {
+	for i := 0; i < 100; i++ {
+		files, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		if len(files) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("queue directory %v was never emptied", dir)
+}
+
+func TestQueue_UploadsAndRemovesEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writeback_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	calls := make(chan *remoteexecution.Digest, 10)
+	q, err := newQueue("test", dir, 2, time.Second, time.Second, handlerInto(calls, nil))
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue("", testDigest))
+
+	assert.EqualValues(t, testDigest, <-calls, "handler should be called with the enqueued digest")
+	waitForEmptyDir(t, dir)
+}
+
+func TestQueue_ResumesAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writeback_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	failingCalls := make(chan *remoteexecution.Digest, 10)
+	failing, err := newQueue("test", dir, 1, time.Hour, time.Second, handlerInto(failingCalls, status.Errorf(codes.Unavailable, "upstream down")))
+	require.NoError(t, err)
+	require.NoError(t, failing.Enqueue("", testDigest))
+	<-failingCalls
+
+	calls := make(chan *remoteexecution.Digest, 10)
+	_, err = newQueue("test", dir, 1, time.Second, time.Second, handlerInto(calls, nil))
+	require.NoError(t, err)
+	assert.EqualValues(t, testDigest, <-calls, "restarted queue should pick up the pending entry")
+	waitForEmptyDir(t, dir)
+}
+
+func TestQueue_DropsOnPermanentError(t *testing.T) {
+	for _, code := range []codes.Code{codes.NotFound, codes.InvalidArgument, codes.FailedPrecondition, codes.PermissionDenied} {
+		t.Run(code.String(), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "writeback_test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			calls := make(chan *remoteexecution.Digest, 10)
+			q, err := newQueue("test", dir, 1, time.Hour, time.Second, handlerInto(calls, status.Errorf(code, "rejected")))
+			require.NoError(t, err)
+			require.NoError(t, q.Enqueue("", testDigest))
+			<-calls
+			waitForEmptyDir(t, dir)
+		})
+	}
+}
+
+func TestQueue_UploadsAgainWhenEnqueuedInFlight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writeback_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	calls := make(chan *remoteexecution.Digest, 10)
+	release := make(chan struct{})
+	q, err := newQueue("test", dir, 1, time.Second, time.Second, func(ctx context.Context, instanceName string, digest *remoteexecution.Digest) error {
+		calls <- digest
+		<-release
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue("", testDigest))
+	<-calls
+	require.NoError(t, q.Enqueue("", testDigest), "enqueuing a digest in flight should succeed")
+	close(release)
+
+	select {
+	case digest := <-calls:
+		assert.EqualValues(t, testDigest, digest, "digest enqueued in flight should be uploaded again")
+	case <-time.After(time.Second):
+		t.Fatalf("digest enqueued in flight was not uploaded again")
+	}
+	waitForEmptyDir(t, dir)
+	assert.Len(t, calls, 0, "digest should be uploaded again only once")
+}
@@ -0,0 +1,548 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: distcache/admin/admin.proto
+
+package distcache_admin
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import v1test "google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type GetActionResultRequest struct {
+	// The instance of the execution system to operate against.
+	InstanceName string `protobuf:"bytes,1,opt,name=instance_name,json=instanceName,proto3" json:"instance_name,omitempty"`
+	// The digest of the action, of which the result is read.
+	ActionDigest         *v1test.Digest `protobuf:"bytes,2,opt,name=action_digest,json=actionDigest,proto3" json:"action_digest,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *GetActionResultRequest) Reset()         { *m = GetActionResultRequest{} }
+func (m *GetActionResultRequest) String() string { return proto.CompactTextString(m) }
+func (*GetActionResultRequest) ProtoMessage()    {}
+func (*GetActionResultRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_admin_387e782c0aa04bd9, []int{0}
+}
+func (m *GetActionResultRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetActionResultRequest.Unmarshal(m, b)
+}
+func (m *GetActionResultRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetActionResultRequest.Marshal(b, m, deterministic)
+}
+func (dst *GetActionResultRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetActionResultRequest.Merge(dst, src)
+}
+func (m *GetActionResultRequest) XXX_Size() int {
+	return xxx_messageInfo_GetActionResultRequest.Size(m)
+}
+func (m *GetActionResultRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetActionResultRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetActionResultRequest proto.InternalMessageInfo
+
+func (m *GetActionResultRequest) GetInstanceName() string {
+	if m != nil {
+		return m.InstanceName
+	}
+	return ""
+}
+
+func (m *GetActionResultRequest) GetActionDigest() *v1test.Digest {
+	if m != nil {
+		return m.ActionDigest
+	}
+	return nil
+}
+
+type DeleteActionResultRequest struct {
+	// The instance of the execution system to operate against.
+	InstanceName string `protobuf:"bytes,1,opt,name=instance_name,json=instanceName,proto3" json:"instance_name,omitempty"`
+	// The digest of the action, of which the result is deleted.
+	ActionDigest         *v1test.Digest `protobuf:"bytes,2,opt,name=action_digest,json=actionDigest,proto3" json:"action_digest,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *DeleteActionResultRequest) Reset()         { *m = DeleteActionResultRequest{} }
+func (m *DeleteActionResultRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteActionResultRequest) ProtoMessage()    {}
+func (*DeleteActionResultRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_admin_387e782c0aa04bd9, []int{1}
+}
+func (m *DeleteActionResultRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteActionResultRequest.Unmarshal(m, b)
+}
+func (m *DeleteActionResultRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteActionResultRequest.Marshal(b, m, deterministic)
+}
+func (dst *DeleteActionResultRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteActionResultRequest.Merge(dst, src)
+}
+func (m *DeleteActionResultRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteActionResultRequest.Size(m)
+}
+func (m *DeleteActionResultRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteActionResultRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteActionResultRequest proto.InternalMessageInfo
+
+func (m *DeleteActionResultRequest) GetInstanceName() string {
+	if m != nil {
+		return m.InstanceName
+	}
+	return ""
+}
+
+func (m *DeleteActionResultRequest) GetActionDigest() *v1test.Digest {
+	if m != nil {
+		return m.ActionDigest
+	}
+	return nil
+}
+
+type DeleteActionResultResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteActionResultResponse) Reset()         { *m = DeleteActionResultResponse{} }
+func (m *DeleteActionResultResponse) String() string { return proto.CompactTextString(m) }
+func (*DeleteActionResultResponse) ProtoMessage()    {}
+func (*DeleteActionResultResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_admin_387e782c0aa04bd9, []int{2}
+}
+func (m *DeleteActionResultResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteActionResultResponse.Unmarshal(m, b)
+}
+func (m *DeleteActionResultResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteActionResultResponse.Marshal(b, m, deterministic)
+}
+func (dst *DeleteActionResultResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteActionResultResponse.Merge(dst, src)
+}
+func (m *DeleteActionResultResponse) XXX_Size() int {
+	return xxx_messageInfo_DeleteActionResultResponse.Size(m)
+}
+func (m *DeleteActionResultResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteActionResultResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteActionResultResponse proto.InternalMessageInfo
+
+type DeleteBlobRequest struct {
+	// The instance of the execution system to operate against.
+	InstanceName string `protobuf:"bytes,1,opt,name=instance_name,json=instanceName,proto3" json:"instance_name,omitempty"`
+	// The digest of the blob to delete.
+	Digest               *v1test.Digest `protobuf:"bytes,2,opt,name=digest,proto3" json:"digest,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *DeleteBlobRequest) Reset()         { *m = DeleteBlobRequest{} }
+func (m *DeleteBlobRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteBlobRequest) ProtoMessage()    {}
+func (*DeleteBlobRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_admin_387e782c0aa04bd9, []int{3}
+}
+func (m *DeleteBlobRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteBlobRequest.Unmarshal(m, b)
+}
+func (m *DeleteBlobRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteBlobRequest.Marshal(b, m, deterministic)
+}
+func (dst *DeleteBlobRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteBlobRequest.Merge(dst, src)
+}
+func (m *DeleteBlobRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteBlobRequest.Size(m)
+}
+func (m *DeleteBlobRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteBlobRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteBlobRequest proto.InternalMessageInfo
+
+func (m *DeleteBlobRequest) GetInstanceName() string {
+	if m != nil {
+		return m.InstanceName
+	}
+	return ""
+}
+
+func (m *DeleteBlobRequest) GetDigest() *v1test.Digest {
+	if m != nil {
+		return m.Digest
+	}
+	return nil
+}
+
+type DeleteBlobResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteBlobResponse) Reset()         { *m = DeleteBlobResponse{} }
+func (m *DeleteBlobResponse) String() string { return proto.CompactTextString(m) }
+func (*DeleteBlobResponse) ProtoMessage()    {}
+func (*DeleteBlobResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_admin_387e782c0aa04bd9, []int{4}
+}
+func (m *DeleteBlobResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteBlobResponse.Unmarshal(m, b)
+}
+func (m *DeleteBlobResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteBlobResponse.Marshal(b, m, deterministic)
+}
+func (dst *DeleteBlobResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteBlobResponse.Merge(dst, src)
+}
+func (m *DeleteBlobResponse) XXX_Size() int {
+	return xxx_messageInfo_DeleteBlobResponse.Size(m)
+}
+func (m *DeleteBlobResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteBlobResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteBlobResponse proto.InternalMessageInfo
+
+type GetStatsRequest struct {
+	// The instance of the execution system to operate against.
+	InstanceName         string   `protobuf:"bytes,1,opt,name=instance_name,json=instanceName,proto3" json:"instance_name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetStatsRequest) Reset()         { *m = GetStatsRequest{} }
+func (m *GetStatsRequest) String() string { return proto.CompactTextString(m) }
+func (*GetStatsRequest) ProtoMessage()    {}
+func (*GetStatsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_admin_387e782c0aa04bd9, []int{5}
+}
+func (m *GetStatsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetStatsRequest.Unmarshal(m, b)
+}
+func (m *GetStatsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetStatsRequest.Marshal(b, m, deterministic)
+}
+func (dst *GetStatsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetStatsRequest.Merge(dst, src)
+}
+func (m *GetStatsRequest) XXX_Size() int {
+	return xxx_messageInfo_GetStatsRequest.Size(m)
+}
+func (m *GetStatsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetStatsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetStatsRequest proto.InternalMessageInfo
+
+func (m *GetStatsRequest) GetInstanceName() string {
+	if m != nil {
+		return m.InstanceName
+	}
+	return ""
+}
+
+type GetStatsResponse struct {
+	// Number of action results stored.
+	ActionResults int64 `protobuf:"varint,1,opt,name=action_results,json=actionResults,proto3" json:"action_results,omitempty"`
+	// Number of blobs stored.
+	Blobs int64 `protobuf:"varint,2,opt,name=blobs,proto3" json:"blobs,omitempty"`
+	// Total size of the blobs stored.
+	BlobBytes            int64    `protobuf:"varint,3,opt,name=blob_bytes,json=blobBytes,proto3" json:"blob_bytes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetStatsResponse) Reset()         { *m = GetStatsResponse{} }
+func (m *GetStatsResponse) String() string { return proto.CompactTextString(m) }
+func (*GetStatsResponse) ProtoMessage()    {}
+func (*GetStatsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_admin_387e782c0aa04bd9, []int{6}
+}
+func (m *GetStatsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetStatsResponse.Unmarshal(m, b)
+}
+func (m *GetStatsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetStatsResponse.Marshal(b, m, deterministic)
+}
+func (dst *GetStatsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetStatsResponse.Merge(dst, src)
+}
+func (m *GetStatsResponse) XXX_Size() int {
+	return xxx_messageInfo_GetStatsResponse.Size(m)
+}
+func (m *GetStatsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetStatsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetStatsResponse proto.InternalMessageInfo
+
+func (m *GetStatsResponse) GetActionResults() int64 {
+	if m != nil {
+		return m.ActionResults
+	}
+	return 0
+}
+
+func (m *GetStatsResponse) GetBlobs() int64 {
+	if m != nil {
+		return m.Blobs
+	}
+	return 0
+}
+
+func (m *GetStatsResponse) GetBlobBytes() int64 {
+	if m != nil {
+		return m.BlobBytes
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*GetActionResultRequest)(nil), "distcache.admin.GetActionResultRequest")
+	proto.RegisterType((*DeleteActionResultRequest)(nil), "distcache.admin.DeleteActionResultRequest")
+	proto.RegisterType((*DeleteActionResultResponse)(nil), "distcache.admin.DeleteActionResultResponse")
+	proto.RegisterType((*DeleteBlobRequest)(nil), "distcache.admin.DeleteBlobRequest")
+	proto.RegisterType((*DeleteBlobResponse)(nil), "distcache.admin.DeleteBlobResponse")
+	proto.RegisterType((*GetStatsRequest)(nil), "distcache.admin.GetStatsRequest")
+	proto.RegisterType((*GetStatsResponse)(nil), "distcache.admin.GetStatsResponse")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type AdminClient interface {
+	// GetActionResult reads an action result straight from the store. Unlike the ActionCache API, it doesn't apply
+	// any policies, check completeness or read from upstream, and never deletes the result.
+	GetActionResult(ctx context.Context, in *GetActionResultRequest, opts ...grpc.CallOption) (*v1test.ActionResult, error)
+	// DeleteActionResult removes an action result from the store, succeeding if it doesn't exist.
+	DeleteActionResult(ctx context.Context, in *DeleteActionResultRequest, opts ...grpc.CallOption) (*DeleteActionResultResponse, error)
+	// DeleteBlob removes a blob from the store, succeeding if it doesn't exist.
+	DeleteBlob(ctx context.Context, in *DeleteBlobRequest, opts ...grpc.CallOption) (*DeleteBlobResponse, error)
+	// GetStats counts the contents of the stores of an instance. It walks all of them, so can take a while.
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error)
+}
+
+type adminClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAdminClient(cc *grpc.ClientConn) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) GetActionResult(ctx context.Context, in *GetActionResultRequest, opts ...grpc.CallOption) (*v1test.ActionResult, error) {
+	out := new(v1test.ActionResult)
+	err := c.cc.Invoke(ctx, "/distcache.admin.Admin/GetActionResult", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DeleteActionResult(ctx context.Context, in *DeleteActionResultRequest, opts ...grpc.CallOption) (*DeleteActionResultResponse, error) {
+	out := new(DeleteActionResultResponse)
+	err := c.cc.Invoke(ctx, "/distcache.admin.Admin/DeleteActionResult", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DeleteBlob(ctx context.Context, in *DeleteBlobRequest, opts ...grpc.CallOption) (*DeleteBlobResponse, error) {
+	out := new(DeleteBlobResponse)
+	err := c.cc.Invoke(ctx, "/distcache.admin.Admin/DeleteBlob", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error) {
+	out := new(GetStatsResponse)
+	err := c.cc.Invoke(ctx, "/distcache.admin.Admin/GetStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+type AdminServer interface {
+	// GetActionResult reads an action result straight from the store. Unlike the ActionCache API, it doesn't apply
+	// any policies, check completeness or read from upstream, and never deletes the result.
+	GetActionResult(context.Context, *GetActionResultRequest) (*v1test.ActionResult, error)
+	// DeleteActionResult removes an action result from the store, succeeding if it doesn't exist.
+	DeleteActionResult(context.Context, *DeleteActionResultRequest) (*DeleteActionResultResponse, error)
+	// DeleteBlob removes a blob from the store, succeeding if it doesn't exist.
+	DeleteBlob(context.Context, *DeleteBlobRequest) (*DeleteBlobResponse, error)
+	// GetStats counts the contents of the stores of an instance. It walks all of them, so can take a while.
+	GetStats(context.Context, *GetStatsRequest) (*GetStatsResponse, error)
+}
+
+func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
+	s.RegisterService(&_Admin_serviceDesc, srv)
+}
+
+func _Admin_GetActionResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetActionResultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetActionResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/distcache.admin.Admin/GetActionResult",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetActionResult(ctx, req.(*GetActionResultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DeleteActionResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteActionResultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DeleteActionResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/distcache.admin.Admin/DeleteActionResult",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DeleteActionResult(ctx, req.(*DeleteActionResultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DeleteBlob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteBlobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DeleteBlob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/distcache.admin.Admin/DeleteBlob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DeleteBlob(ctx, req.(*DeleteBlobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/distcache.admin.Admin/GetStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Admin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "distcache.admin.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetActionResult",
+			Handler:    _Admin_GetActionResult_Handler,
+		},
+		{
+			MethodName: "DeleteActionResult",
+			Handler:    _Admin_DeleteActionResult_Handler,
+		},
+		{
+			MethodName: "DeleteBlob",
+			Handler:    _Admin_DeleteBlob_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Admin_GetStats_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "distcache/admin/admin.proto",
+}
+
+func init() { proto.RegisterFile("distcache/admin/admin.proto", fileDescriptor_admin_387e782c0aa04bd9) }
+
+var fileDescriptor_admin_387e782c0aa04bd9 = []byte{
+	// 416 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x94, 0xc1, 0xab, 0xd3, 0x40,
+	0x10, 0xc6, 0x89, 0xe1, 0x3d, 0x7c, 0x63, 0x6b, 0xed, 0x52, 0xa4, 0x46, 0x85, 0x9a, 0x22, 0x16,
+	0x85, 0x0d, 0x56, 0xf1, 0xe6, 0xa1, 0xa5, 0xd8, 0x9b, 0x42, 0x8a, 0x17, 0x2f, 0x61, 0x93, 0x0c,
+	0x35, 0x90, 0x64, 0x6b, 0x77, 0x5a, 0xf4, 0xe6, 0x9f, 0x20, 0x82, 0xff, 0xaf, 0x64, 0x37, 0x69,
+	0xd3, 0xda, 0x96, 0x16, 0x2f, 0xef, 0x52, 0xba, 0xdf, 0xce, 0x4c, 0x7e, 0xfb, 0xed, 0xc7, 0xc2,
+	0xe3, 0x38, 0x51, 0x14, 0x89, 0xe8, 0x2b, 0x7a, 0x22, 0xce, 0x92, 0xdc, 0xfc, 0xf2, 0xc5, 0x52,
+	0x92, 0x64, 0xad, 0xcd, 0x26, 0xd7, 0xb2, 0xf3, 0x7e, 0x2e, 0xe5, 0x3c, 0x45, 0x2f, 0xc6, 0x35,
+	0x49, 0x99, 0x2a, 0x6f, 0x89, 0x99, 0x24, 0xc4, 0xef, 0x18, 0xad, 0x28, 0x91, 0xb9, 0xb7, 0x7e,
+	0x4d, 0xa8, 0xa8, 0x94, 0x83, 0x8d, 0x6e, 0xe6, 0xb9, 0xbf, 0x2d, 0x78, 0x38, 0x45, 0x1a, 0x45,
+	0x85, 0xe6, 0xa3, 0x5a, 0xa5, 0xe4, 0xe3, 0xb7, 0x15, 0x2a, 0x62, 0x7d, 0x68, 0x26, 0xb9, 0x22,
+	0x91, 0x47, 0x18, 0xe4, 0x22, 0xc3, 0xae, 0xd5, 0xb3, 0x06, 0x37, 0x7e, 0xa3, 0x12, 0x3f, 0x8a,
+	0x0c, 0xd9, 0x0c, 0x9a, 0x42, 0xf7, 0x06, 0x71, 0x32, 0x47, 0x45, 0xdd, 0x3b, 0x3d, 0x6b, 0x70,
+	0x6f, 0xc8, 0xb9, 0xc1, 0xe2, 0x15, 0x16, 0xdf, 0xc3, 0xe2, 0x06, 0x8b, 0x4f, 0x74, 0x97, 0xdf,
+	0x30, 0x43, 0xcc, 0xca, 0xfd, 0x63, 0xc1, 0xa3, 0x09, 0xa6, 0x48, 0x78, 0xbb, 0xb8, 0x9e, 0x80,
+	0x73, 0x08, 0x4b, 0x2d, 0x64, 0xae, 0xd0, 0xfd, 0x69, 0x41, 0xdb, 0x6c, 0x8f, 0x53, 0x19, 0x5e,
+	0x44, 0xfb, 0x01, 0xae, 0xff, 0x0b, 0xb3, 0xec, 0x76, 0x3b, 0xc0, 0xea, 0x04, 0x25, 0xd8, 0x3b,
+	0x68, 0x4d, 0x91, 0x66, 0x24, 0x48, 0x5d, 0x42, 0xe5, 0xe6, 0xf0, 0x60, 0xdb, 0x67, 0x66, 0xb1,
+	0xe7, 0x70, 0xbf, 0xf4, 0x75, 0xa9, 0x4f, 0xaf, 0x74, 0xa7, 0xed, 0x37, 0x45, 0xcd, 0x12, 0xc5,
+	0x3a, 0x70, 0x15, 0xa6, 0x32, 0x54, 0xfa, 0x3c, 0xb6, 0x6f, 0x16, 0xec, 0x29, 0x40, 0xf1, 0x27,
+	0x08, 0x7f, 0x10, 0xaa, 0xae, 0xad, 0xb7, 0x6e, 0x0a, 0x65, 0x5c, 0x08, 0xc3, 0x5f, 0x36, 0x5c,
+	0x8d, 0x8a, 0x50, 0xb3, 0x85, 0x26, 0xae, 0xbb, 0xcc, 0x5e, 0xf0, 0xbd, 0xe4, 0xf3, 0xc3, 0xb1,
+	0x75, 0xde, 0x9e, 0xeb, 0xdd, 0xce, 0xf8, 0xac, 0x72, 0x6e, 0x47, 0x7d, 0xf9, 0xcf, 0x47, 0x8f,
+	0xc6, 0xd2, 0x79, 0x75, 0x56, 0x6d, 0x69, 0xe3, 0x67, 0x80, 0xed, 0x45, 0x31, 0xf7, 0x48, 0x6b,
+	0x2d, 0x47, 0x4e, 0xff, 0x64, 0x4d, 0x39, 0xf6, 0x13, 0xdc, 0xad, 0x6e, 0x8c, 0xf5, 0x0e, 0x19,
+	0x56, 0x0f, 0x81, 0xf3, 0xec, 0x44, 0x85, 0x19, 0x38, 0x6e, 0x7f, 0xd9, 0x3e, 0x38, 0x81, 0xae,
+	0x09, 0xaf, 0xf5, 0xc3, 0xf1, 0xe6, 0xef, 0x00, 0x36, 0x87, 0xbd, 0x35, 0xa7, 0x04, 0x00, 0x00,
+}
@@ -0,0 +1,287 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: distcache/cas/cas_extensions.proto
+
+package distcache_cas
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import v1test "google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+import status "google.golang.org/genproto/googleapis/rpc/status"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type BatchReadBlobsRequest struct {
+	// The instance of the execution system to operate against.
+	InstanceName string `protobuf:"bytes,1,opt,name=instance_name,json=instanceName,proto3" json:"instance_name,omitempty"`
+	// The digests of the blobs to read.
+	Digests              []*v1test.Digest `protobuf:"bytes,2,rep,name=digests,proto3" json:"digests,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *BatchReadBlobsRequest) Reset()         { *m = BatchReadBlobsRequest{} }
+func (m *BatchReadBlobsRequest) String() string { return proto.CompactTextString(m) }
+func (*BatchReadBlobsRequest) ProtoMessage()    {}
+func (*BatchReadBlobsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_cas_extensions_220f7051cd9a8e3f, []int{0}
+}
+func (m *BatchReadBlobsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchReadBlobsRequest.Unmarshal(m, b)
+}
+func (m *BatchReadBlobsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchReadBlobsRequest.Marshal(b, m, deterministic)
+}
+func (dst *BatchReadBlobsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchReadBlobsRequest.Merge(dst, src)
+}
+func (m *BatchReadBlobsRequest) XXX_Size() int {
+	return xxx_messageInfo_BatchReadBlobsRequest.Size(m)
+}
+func (m *BatchReadBlobsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchReadBlobsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchReadBlobsRequest proto.InternalMessageInfo
+
+func (m *BatchReadBlobsRequest) GetInstanceName() string {
+	if m != nil {
+		return m.InstanceName
+	}
+	return ""
+}
+
+func (m *BatchReadBlobsRequest) GetDigests() []*v1test.Digest {
+	if m != nil {
+		return m.Digests
+	}
+	return nil
+}
+
+type BatchReadBlobsResponse struct {
+	// One response per requested digest, in the order of the request.
+	Responses            []*BatchReadBlobsResponse_Response `protobuf:"bytes,1,rep,name=responses,proto3" json:"responses,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                           `json:"-"`
+	XXX_unrecognized     []byte                             `json:"-"`
+	XXX_sizecache        int32                              `json:"-"`
+}
+
+func (m *BatchReadBlobsResponse) Reset()         { *m = BatchReadBlobsResponse{} }
+func (m *BatchReadBlobsResponse) String() string { return proto.CompactTextString(m) }
+func (*BatchReadBlobsResponse) ProtoMessage()    {}
+func (*BatchReadBlobsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_cas_extensions_220f7051cd9a8e3f, []int{1}
+}
+func (m *BatchReadBlobsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchReadBlobsResponse.Unmarshal(m, b)
+}
+func (m *BatchReadBlobsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchReadBlobsResponse.Marshal(b, m, deterministic)
+}
+func (dst *BatchReadBlobsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchReadBlobsResponse.Merge(dst, src)
+}
+func (m *BatchReadBlobsResponse) XXX_Size() int {
+	return xxx_messageInfo_BatchReadBlobsResponse.Size(m)
+}
+func (m *BatchReadBlobsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchReadBlobsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchReadBlobsResponse proto.InternalMessageInfo
+
+func (m *BatchReadBlobsResponse) GetResponses() []*BatchReadBlobsResponse_Response {
+	if m != nil {
+		return m.Responses
+	}
+	return nil
+}
+
+type BatchReadBlobsResponse_Response struct {
+	// The digest to which this response corresponds.
+	Digest *v1test.Digest `protobuf:"bytes,1,opt,name=digest,proto3" json:"digest,omitempty"`
+	// The raw binary data, only set if the status is OK.
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// The result of attempting to read the blob, `NOT_FOUND` if it doesn't exist.
+	Status               *status.Status `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *BatchReadBlobsResponse_Response) Reset()         { *m = BatchReadBlobsResponse_Response{} }
+func (m *BatchReadBlobsResponse_Response) String() string { return proto.CompactTextString(m) }
+func (*BatchReadBlobsResponse_Response) ProtoMessage()    {}
+func (*BatchReadBlobsResponse_Response) Descriptor() ([]byte, []int) {
+	return fileDescriptor_cas_extensions_220f7051cd9a8e3f, []int{1, 0}
+}
+func (m *BatchReadBlobsResponse_Response) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchReadBlobsResponse_Response.Unmarshal(m, b)
+}
+func (m *BatchReadBlobsResponse_Response) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchReadBlobsResponse_Response.Marshal(b, m, deterministic)
+}
+func (dst *BatchReadBlobsResponse_Response) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchReadBlobsResponse_Response.Merge(dst, src)
+}
+func (m *BatchReadBlobsResponse_Response) XXX_Size() int {
+	return xxx_messageInfo_BatchReadBlobsResponse_Response.Size(m)
+}
+func (m *BatchReadBlobsResponse_Response) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchReadBlobsResponse_Response.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchReadBlobsResponse_Response proto.InternalMessageInfo
+
+func (m *BatchReadBlobsResponse_Response) GetDigest() *v1test.Digest {
+	if m != nil {
+		return m.Digest
+	}
+	return nil
+}
+
+func (m *BatchReadBlobsResponse_Response) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *BatchReadBlobsResponse_Response) GetStatus() *status.Status {
+	if m != nil {
+		return m.Status
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*BatchReadBlobsRequest)(nil), "distcache.cas.BatchReadBlobsRequest")
+	proto.RegisterType((*BatchReadBlobsResponse)(nil), "distcache.cas.BatchReadBlobsResponse")
+	proto.RegisterType((*BatchReadBlobsResponse_Response)(nil), "distcache.cas.BatchReadBlobsResponse.Response")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// ContentAddressableStorageExtensionsClient is the client API for ContentAddressableStorageExtensions service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ContentAddressableStorageExtensionsClient interface {
+	// BatchReadBlobs reads many small blobs in a single round trip.
+	//
+	// The total size of the data returned is limited by the server. Blobs that don't fit into the response are
+	// returned with a `RESOURCE_EXHAUSTED` status, and should be read using the ByteStream API instead.
+	BatchReadBlobs(ctx context.Context, in *BatchReadBlobsRequest, opts ...grpc.CallOption) (*BatchReadBlobsResponse, error)
+}
+
+type contentAddressableStorageExtensionsClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewContentAddressableStorageExtensionsClient(cc *grpc.ClientConn) ContentAddressableStorageExtensionsClient {
+	return &contentAddressableStorageExtensionsClient{cc}
+}
+
+func (c *contentAddressableStorageExtensionsClient) BatchReadBlobs(ctx context.Context, in *BatchReadBlobsRequest, opts ...grpc.CallOption) (*BatchReadBlobsResponse, error) {
+	out := new(BatchReadBlobsResponse)
+	err := c.cc.Invoke(ctx, "/distcache.cas.ContentAddressableStorageExtensions/BatchReadBlobs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ContentAddressableStorageExtensionsServer is the server API for ContentAddressableStorageExtensions service.
+type ContentAddressableStorageExtensionsServer interface {
+	// BatchReadBlobs reads many small blobs in a single round trip.
+	//
+	// The total size of the data returned is limited by the server. Blobs that don't fit into the response are
+	// returned with a `RESOURCE_EXHAUSTED` status, and should be read using the ByteStream API instead.
+	BatchReadBlobs(context.Context, *BatchReadBlobsRequest) (*BatchReadBlobsResponse, error)
+}
+
+func RegisterContentAddressableStorageExtensionsServer(s *grpc.Server, srv ContentAddressableStorageExtensionsServer) {
+	s.RegisterService(&_ContentAddressableStorageExtensions_serviceDesc, srv)
+}
+
+func _ContentAddressableStorageExtensions_BatchReadBlobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchReadBlobsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContentAddressableStorageExtensionsServer).BatchReadBlobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/distcache.cas.ContentAddressableStorageExtensions/BatchReadBlobs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContentAddressableStorageExtensionsServer).BatchReadBlobs(ctx, req.(*BatchReadBlobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _ContentAddressableStorageExtensions_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "distcache.cas.ContentAddressableStorageExtensions",
+	HandlerType: (*ContentAddressableStorageExtensionsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "BatchReadBlobs",
+			Handler:    _ContentAddressableStorageExtensions_BatchReadBlobs_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "distcache/cas/cas_extensions.proto",
+}
+
+func init() {
+	proto.RegisterFile("distcache/cas/cas_extensions.proto", fileDescriptor_cas_extensions_220f7051cd9a8e3f)
+}
+
+var fileDescriptor_cas_extensions_220f7051cd9a8e3f = []byte{
+	// 354 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x52, 0x4d, 0x4b, 0xc3, 0x40,
+	0x10, 0x65, 0x5b, 0xa9, 0x76, 0x6d, 0x15, 0x16, 0xd4, 0x90, 0x53, 0x69, 0x15, 0x8a, 0x87, 0x0d,
+	0xd6, 0xb3, 0x07, 0xeb, 0x07, 0x1e, 0xc4, 0x43, 0x7a, 0x13, 0x24, 0x6c, 0x37, 0x43, 0x1a, 0x48,
+	0x77, 0x63, 0x66, 0x5a, 0xfa, 0x03, 0xc4, 0x1f, 0xe0, 0xdf, 0xf5, 0x22, 0xe6, 0xab, 0xb4, 0x08,
+	0xea, 0x21, 0x30, 0x4c, 0xe6, 0xbd, 0x79, 0xf3, 0xf6, 0xf1, 0x7e, 0x18, 0x23, 0x69, 0xa5, 0x67,
+	0xe0, 0x69, 0x85, 0xdf, 0x5f, 0x00, 0x2b, 0x02, 0x83, 0xb1, 0x35, 0x28, 0xd3, 0xcc, 0x92, 0x15,
+	0xdd, 0x7a, 0x46, 0x6a, 0x85, 0xee, 0x55, 0x64, 0x6d, 0x94, 0x80, 0x17, 0xc2, 0x92, 0xac, 0x4d,
+	0xd0, 0xcb, 0x60, 0x6e, 0x09, 0x60, 0x05, 0x7a, 0x41, 0xb1, 0x35, 0xde, 0xf2, 0x82, 0x00, 0xa9,
+	0x6c, 0x07, 0x75, 0xbf, 0x60, 0x73, 0x4f, 0x4a, 0x78, 0x96, 0x6a, 0x0f, 0x49, 0xd1, 0xa2, 0x5c,
+	0xd3, 0x7f, 0x67, 0xfc, 0x68, 0xac, 0x48, 0xcf, 0x7c, 0x50, 0xe1, 0x38, 0xb1, 0x53, 0xf4, 0xe1,
+	0x75, 0x01, 0x48, 0x62, 0xc0, 0xbb, 0xb1, 0x41, 0x52, 0x46, 0x43, 0x60, 0xd4, 0x1c, 0x1c, 0xd6,
+	0x63, 0xc3, 0xb6, 0xdf, 0xa9, 0x9a, 0x4f, 0x6a, 0x0e, 0xe2, 0x81, 0xef, 0x86, 0x71, 0x04, 0x48,
+	0xe8, 0x34, 0x7a, 0xcd, 0xe1, 0xfe, 0x48, 0xca, 0x62, 0x93, 0xac, 0x84, 0xca, 0x2d, 0xa1, 0xb2,
+	0x10, 0x2a, 0x6f, 0x73, 0x98, 0x5f, 0xc1, 0xfb, 0x9f, 0x8c, 0x1f, 0x6f, 0x0b, 0xc1, 0xd4, 0x1a,
+	0x04, 0xf1, 0xc8, 0xdb, 0x59, 0x59, 0xa3, 0xc3, 0xca, 0x35, 0x1b, 0xf6, 0xc8, 0x9f, 0x91, 0xb2,
+	0x2a, 0xfc, 0x35, 0x81, 0xfb, 0xc1, 0xf8, 0x5e, 0x4d, 0x7d, 0xcf, 0x5b, 0x85, 0x80, 0xfc, 0xba,
+	0xff, 0xcb, 0x2f, 0xd1, 0x42, 0xf0, 0x9d, 0x50, 0x91, 0x72, 0x1a, 0x3d, 0x36, 0xec, 0xf8, 0x79,
+	0x2d, 0xce, 0x79, 0xab, 0xb0, 0xda, 0x69, 0xe6, 0xdc, 0xa2, 0xe2, 0xce, 0x52, 0x2d, 0x27, 0xf9,
+	0x1f, 0xbf, 0x9c, 0x18, 0xbd, 0x31, 0x3e, 0xb8, 0xb1, 0x86, 0xc0, 0xd0, 0x75, 0x18, 0x66, 0x80,
+	0xa8, 0xa6, 0x09, 0x4c, 0xc8, 0x66, 0x2a, 0x82, 0xbb, 0x3a, 0x1b, 0xe2, 0x85, 0x1f, 0x6c, 0x9e,
+	0x2a, 0x4e, 0x7f, 0x71, 0x22, 0x7f, 0x4c, 0xf7, 0xec, 0x4f, 0x7e, 0x8d, 0x0f, 0x9f, 0xd7, 0xb1,
+	0x0b, 0xb4, 0xc2, 0x69, 0x2b, 0x4f, 0xc9, 0xe5, 0xd7, 0x00, 0xbb, 0x64, 0x57, 0xfd, 0xb2, 0x02,
+	0x00, 0x00,
+}
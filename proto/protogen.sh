@@ -5,6 +5,8 @@ SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd -P)"
 PROTOBUF_DIR=${PROTOBUF_DIR-${SCRIPT_DIR}/.}
 PROTOGEN_DIR=.
 GENERATION_DIR=${GENERATION_DIR-${SCRIPT_DIR}/${PROTOGEN_DIR}}
+# Checkout of https://github.com/googleapis/googleapis, for the remoteexecution and rpc protos.
+GOOGLEAPIS_DIR=${GOOGLEAPIS_DIR-${GOPATH}/src/github.com/googleapis/googleapis}
 
 # Builds all .proto files in a given package dirctory.
 # NOTE: All .proto files in a given package must be processed *together*, otherwise the self-referencing
@@ -17,6 +19,7 @@ function proto_build_dir {
   mkdir -p ${GENERATION_DIR}/${DIR_REL} 2> /dev/null
   PATH=${GOPATH}/bin:$PATH protoc \
     -I${PROTOBUF_DIR} \
+    -I${GOOGLEAPIS_DIR} \
     --go_out=plugins=grpc:${GENERATION_DIR} \
     ${DIR_FULL}/*.proto || exit $?
   echo "DONE"
@@ -0,0 +1,49 @@
+package actioncache
+
+import (
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkComplete makes sure that all blobs referenced by the action result exist, as bazel fails the build if it can't
+// download the outputs of a hit. Incomplete results are reported as misses, so that bazel runs the action again.
+func (l *local) checkComplete(ctx context.Context, instanceName string, store action.Store, actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error {
+	referenced := referencedBlobs(actionResult)
+	if len(referenced) == 0 {
+		return nil
+	}
+	resp, err := l.blobs.FindMissingBlobs(ctx, &remoteexecution.FindMissingBlobsRequest{
+		InstanceName: instanceName,
+		BlobDigests:  referenced,
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.MissingBlobDigests) == 0 {
+		return nil
+	}
+	incompleteCounter.Inc()
+	logrus.WithField("action", actionDigest.Hash).Infof("action result references %d missing blobs, treating as a miss", len(resp.MissingBlobDigests))
+	if l.cfg.DeleteIncomplete {
+		if err := store.Delete(actionDigest); err != nil {
+			logrus.WithError(err).Warnf("failed deleting incomplete action result")
+		}
+	}
+	return status.Errorf(codes.NotFound, "action result references missing blobs")
+}
+
+// referencedBlobs returns the blobs referenced by the action result, skipping empty ones as clients don't need to
+// upload them.
+func referencedBlobs(actionResult *remoteexecution.ActionResult) []*remoteexecution.Digest {
+	var ret []*remoteexecution.Digest
+	for _, d := range action.ReferencedBlobs(actionResult) {
+		if d.SizeBytes > 0 {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,75 @@
+package actioncache
+
+import (
+	"testing"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeBlobs struct {
+	remoteexecution.ContentAddressableStorageServer
+	existing map[string]bool
+}
+
+func (f *fakeBlobs) FindMissingBlobs(ctx context.Context, req *remoteexecution.FindMissingBlobsRequest) (*remoteexecution.FindMissingBlobsResponse, error) {
+	resp := &remoteexecution.FindMissingBlobsResponse{}
+	for _, d := range req.BlobDigests {
+		if !f.existing[d.Hash] {
+			resp.MissingBlobDigests = append(resp.MissingBlobDigests, d)
+		}
+	}
+	return resp, nil
+}
+
+func TestGetActionResult_IncompleteIsMiss(t *testing.T) {
+	output := util.DataToContentDigest(util.SHA256, []byte("output"))
+	stdout := util.DataToContentDigest(util.SHA256, []byte("stdout"))
+	actionDigest := util.DataToContentDigest(util.SHA256, []byte("action"))
+	actionResult := &remoteexecution.ActionResult{
+		OutputFiles:  []*remoteexecution.OutputFile{{Path: "out", Digest: output}},
+		StdoutDigest: stdout,
+		// Empty blobs don't need to exist.
+		StderrDigest: util.DataToContentDigest(util.SHA256, []byte{}),
+	}
+	for _, tcase := range []struct {
+		name             string
+		existing         []*remoteexecution.Digest
+		deleteIncomplete bool
+		code             codes.Code
+		deleted          bool
+	}{
+		{name: "complete", existing: []*remoteexecution.Digest{output, stdout}, code: codes.OK},
+		{name: "missing_output", existing: []*remoteexecution.Digest{stdout}, code: codes.NotFound},
+		{name: "missing_stdout", existing: []*remoteexecution.Digest{output}, code: codes.NotFound},
+		{name: "missing_deleted", existing: []*remoteexecution.Digest{output}, deleteIncomplete: true, code: codes.NotFound, deleted: true},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			blobs := &fakeBlobs{existing: make(map[string]bool)}
+			for _, d := range tcase.existing {
+				blobs.existing[d.Hash] = true
+			}
+			store := action.NewInMemory()
+			require.NoError(t, store.Store(actionDigest, actionResult))
+			policy, err := newUpdatePolicy(FailedResultsStore, 0, nil, "")
+			require.NoError(t, err)
+			l := &local{
+				cfg:    Config{DeleteIncomplete: tcase.deleteIncomplete},
+				stores: action.NewPerInstance(func(string) (action.Store, error) { return store, nil }),
+				blobs:  blobs,
+				policy: policy,
+			}
+
+			_, err = l.GetActionResult(context.Background(), &remoteexecution.GetActionResultRequest{ActionDigest: actionDigest})
+			assert.Equal(t, tcase.code, status.Code(err))
+			_, err = store.Get(actionDigest)
+			assert.Equal(t, tcase.deleted, status.Code(err) == codes.NotFound, "deletion of the action result")
+		})
+	}
+}
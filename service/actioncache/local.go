@@ -1,47 +1,164 @@
 package actioncache
 
 import (
+	"fmt"
+	"time"
+
+	remoteexecution_v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/mwitkow/bazel-distcache/common/writeback"
 	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	incompleteCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "distcache",
+			Subsystem: "actioncache",
+			Name:      "incomplete_results_total",
+			Help:      "Number of action results treated as misses because blobs they reference are missing.",
+		})
 )
 
-// NewLocal builds the CaS gRPC service for local daemon.
-func NewLocal() remoteexecution.ActionCacheServer {
-	store, err := action.NewOnDisk()
+func init() {
+	prometheus.MustRegister(incompleteCounter)
+}
+
+// Config configures the ActionCache service.
+type Config struct {
+	// UpdateEnabled is whether clients may store action results. If false, the cache is read-only for clients.
+	UpdateEnabled bool
+	// DeleteIncomplete is whether action results referencing missing blobs are deleted from the store, rather than
+	// only treated as misses.
+	DeleteIncomplete bool
+	// FailedResults is what to do with results of actions with a non-zero exit code, one of the FailedResults*.
+	FailedResults string
+	// FailedResultsTTL is the time for which results of failed actions are served, with FailedResultsExpire.
+	FailedResultsTTL time.Duration
+	// FailedResultsPath is the directory recording when results of failed actions were stored, with
+	// FailedResultsExpire.
+	FailedResultsPath string
+	// TrustedNetworks are CIDRs of clients allowed to store action results. All clients are allowed if empty.
+	TrustedNetworks []string
+	// Writeback configures the queue of action results replicated to upstream, if there is one.
+	Writeback writeback.Config
+}
+
+// ConcreteActionCacheServer is the v1test ActionCacheServer that can also be served over REAPI v2.
+type ConcreteActionCacheServer interface {
+	remoteexecution.ActionCacheServer
+
+	// V2 returns the REAPI v2 ActionCacheServer backed by the same stores.
+	V2() remoteexecution_v2.ActionCacheServer
+	// UpdateEnabled returns whether clients may store action results.
+	UpdateEnabled() bool
+	// Close stops the background work of the service.
+	Close()
+}
+
+// NewLocal builds the CaS gRPC service for local daemon, serving action results of the stores.
+// Hits are only served if all blobs they reference can be found through the blobs service.
+// If upstream is not nil, local misses are looked up in the upstream cache and stored locally on a hit, and local
+// updates are replicated to it in the background.
+func NewLocal(cfg Config, stores action.Stores, blobs remoteexecution.ContentAddressableStorageServer, upstream *grpc.ClientConn) (ConcreteActionCacheServer, error) {
+	// Make sure the default instance's store can be initialised, as the service is useless otherwise.
+	if _, err := stores.Get(""); err != nil {
+		return nil, fmt.Errorf("could not initialise ActionCache: %v", err)
+	}
+	policy, err := newUpdatePolicy(cfg.FailedResults, cfg.FailedResultsTTL, cfg.TrustedNetworks, cfg.FailedResultsPath)
 	if err != nil {
-		logrus.Fatalf("could not initialise CaSService: %v", err)
+		return nil, fmt.Errorf("could not initialise ActionCache update policy: %v", err)
+	}
+	l := &local{cfg: cfg, stores: stores, blobs: blobs, policy: policy, stop: make(chan struct{})}
+	if upstream != nil {
+		l.upstream = remoteexecution.NewActionCacheClient(upstream)
+		l.writeback, err = writeback.New(cfg.Writeback, "actions", l.writeBack)
+		if err != nil {
+			return nil, fmt.Errorf("could not initialise ActionCache writeback: %v", err)
+		}
 	}
-	return &local{store}
+	if cfg.FailedResults == FailedResultsExpire {
+		go l.expireFailuresLoop()
+	}
+	return l, nil
 }
 
 type local struct {
-	store action.Store
+	cfg       Config
+	stores    action.Stores
+	blobs     remoteexecution.ContentAddressableStorageServer
+	policy    *updatePolicy
+	upstream  remoteexecution.ActionCacheClient
+	writeback *writeback.Queue
+	stop      chan struct{}
 }
 
 func (l *local) GetActionResult(ctx context.Context, req *remoteexecution.GetActionResultRequest) (*remoteexecution.ActionResult, error) {
 	if req.GetActionDigest() == nil {
 		return nil, grpc.Errorf(codes.InvalidArgument, "action digest must be set")
 	}
-	// TODO(mwitkow): Handle req.InstanceName
-	actionResult, err := l.store.Get(req.GetActionDigest())
-	// errors from storage are gRPC so we're good.
-	return actionResult, err
-
+	store, err := l.stores.Get(req.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+	actionResult, err := store.Get(req.GetActionDigest())
+	if status.Code(err) == codes.NotFound && l.upstream != nil {
+		actionResult, err = l.readThrough(ctx, store, req)
+	}
+	if err != nil {
+		// errors from storage are gRPC so we're good.
+		return nil, err
+	}
+	if !l.policy.servable(req.InstanceName, req.ActionDigest, actionResult, time.Now()) {
+		if err := store.Delete(req.ActionDigest); err != nil {
+			logrus.WithError(err).Warnf("failed deleting expired result of failed action")
+		}
+		return nil, status.Errorf(codes.NotFound, "action failed and its result isn't served anymore")
+	}
+	if err := l.checkComplete(ctx, req.InstanceName, store, req.ActionDigest, actionResult); err != nil {
+		return nil, err
+	}
+	return actionResult, nil
 }
 
 func (l *local) UpdateActionResult(ctx context.Context, req *remoteexecution.UpdateActionResultRequest) (*remoteexecution.ActionResult, error) {
-	// TODO(mwitkow): Handle req.InstanceName
 	if req.GetActionDigest() == nil || req.GetActionResult() == nil {
 		return nil, grpc.Errorf(codes.InvalidArgument, "action result and dugest must be set")
 	}
-	err := l.store.Store(req.ActionDigest, req.ActionResult)
+	if !l.UpdateEnabled() {
+		return nil, status.Errorf(codes.PermissionDenied, "action cache updates are disabled")
+	}
+	if err := l.policy.allowUpdate(ctx, req); err != nil {
+		return nil, err
+	}
+	store, err := l.stores.Get(req.InstanceName)
 	if err != nil {
+		return nil, err
+	}
+	if err := store.Store(req.ActionDigest, req.ActionResult); err != nil {
 		// errors from storage are gRPC so we're good.
 		return nil, err
 	}
+	l.policy.stored(req.InstanceName, req.ActionDigest, req.ActionResult, time.Now())
+	if l.writeback != nil {
+		if err := l.writeback.Enqueue(req.InstanceName, req.ActionDigest); err != nil {
+			// The update is stored locally, so don't fail the build over it.
+			logrus.WithError(err).Errorf("failed enqueuing action for upstream writeback")
+		}
+	}
 	return req.ActionResult, nil
 }
+
+func (l *local) UpdateEnabled() bool {
+	return l.cfg.UpdateEnabled
+}
+
+func (l *local) Close() {
+	close(l.stop)
+}
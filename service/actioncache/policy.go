@@ -0,0 +1,305 @@
+package actioncache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// FailedResultsStore stores results of failed actions like any others.
+	FailedResultsStore = "store"
+	// FailedResultsReject doesn't store results of failed actions.
+	FailedResultsReject = "reject"
+	// FailedResultsExpire stores results of failed actions, but only serves them for Config.FailedResultsTTL.
+	FailedResultsExpire = "expire"
+
+	decisionStored          = "stored"
+	decisionStoredFailure   = "stored_failure"
+	decisionRejectedFailure = "rejected_failure"
+	decisionUntrusted       = "rejected_untrusted"
+
+	tmpFilePrefix             = ".tmp-"
+	minFailuresExpiryInterval = time.Minute
+)
+
+var (
+	updateDecisionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "distcache",
+			Subsystem: "actioncache",
+			Name:      "update_decisions_total",
+			Help:      "Number of action result updates, by the decision of the update policy.",
+		}, []string{"decision"})
+)
+
+func init() {
+	prometheus.MustRegister(updateDecisionsCounter)
+}
+
+// updatePolicy decides which action results uploaded by clients are stored, and which of the failed ones are served.
+type updatePolicy struct {
+	failedResults   string
+	failedTTL       time.Duration
+	trustedNetworks []*net.IPNet
+	// failuresPath holds a file for each stored result of a failed action, with FailedResultsExpire. The expiry of the
+	// result is derived from the modification time of its file, so that it survives restarts.
+	failuresPath string
+}
+
+// failure is the content of the file of a stored result of a failed action.
+type failure struct {
+	InstanceName string `json:"instance_name"`
+	Hash         string `json:"hash"`
+	SizeBytes    int64  `json:"size_bytes"`
+}
+
+func newUpdatePolicy(failedResults string, failedTTL time.Duration, trustedCidrs []string, failuresPath string) (*updatePolicy, error) {
+	switch failedResults {
+	case FailedResultsStore, FailedResultsReject:
+	case FailedResultsExpire:
+		if err := os.MkdirAll(failuresPath, 0777); err != nil {
+			return nil, fmt.Errorf("failed action results directory initialization error: %v", err)
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown policy for failed action results %q", failedResults)
+	}
+	p := &updatePolicy{failedResults: failedResults, failedTTL: failedTTL, failuresPath: failuresPath}
+	for _, cidr := range trustedCidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "bad trusted network %q: %v", cidr, err)
+		}
+		p.trustedNetworks = append(p.trustedNetworks, network)
+	}
+	return p, nil
+}
+
+// allowUpdate returns a gRPC error if the action result uploaded by the client mustn't be stored.
+func (p *updatePolicy) allowUpdate(ctx context.Context, req *remoteexecution.UpdateActionResultRequest) error {
+	log := logrus.WithField("action", req.ActionDigest.Hash)
+	if !p.trusted(ctx) {
+		updateDecisionsCounter.WithLabelValues(decisionUntrusted).Inc()
+		log.Infof("rejecting action result of untrusted client %v", peerAddress(ctx))
+		return status.Errorf(codes.PermissionDenied, "client is not allowed to store action results")
+	}
+	if req.ActionResult.ExitCode == 0 {
+		updateDecisionsCounter.WithLabelValues(decisionStored).Inc()
+		return nil
+	}
+	if p.failedResults == FailedResultsReject {
+		updateDecisionsCounter.WithLabelValues(decisionRejectedFailure).Inc()
+		log.Infof("rejecting action result with exit code %d", req.ActionResult.ExitCode)
+		return status.Errorf(codes.FailedPrecondition, "results of failed actions are not stored")
+	}
+	updateDecisionsCounter.WithLabelValues(decisionStoredFailure).Inc()
+	log.Infof("storing action result with exit code %d", req.ActionResult.ExitCode)
+	return nil
+}
+
+// stored records when the action result was stored, if it is of a failed action, so that it expires. It must be called
+// for all results stored, including ones read from upstream, as a success replaces an expiring failure.
+func (p *updatePolicy) stored(instanceName string, actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult, now time.Time) {
+	if p.failedResults != FailedResultsExpire {
+		return
+	}
+	fileName, err := p.failureFile(instanceName, actionDigest)
+	if err != nil {
+		return
+	}
+	if actionResult.ExitCode == 0 {
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).Warnf("failed removing expiry of replaced failed action result")
+		}
+		return
+	}
+	if err := writeFailure(fileName, &failure{InstanceName: instanceName, Hash: actionDigest.Hash, SizeBytes: actionDigest.SizeBytes}, now); err != nil {
+		logrus.WithError(err).Warnf("failed recording expiry of failed action result")
+	}
+}
+
+// servable returns whether the stored action result can be served to clients.
+// Failed results without a record of when they were stored (e.g. stored before expiring them was enabled) are
+// recorded as stored now, so that they expire too.
+func (p *updatePolicy) servable(instanceName string, actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult, now time.Time) bool {
+	if actionResult.ExitCode == 0 || p.failedResults == FailedResultsStore {
+		return true
+	}
+	if p.failedResults == FailedResultsReject {
+		return false
+	}
+	fileName, err := p.failureFile(instanceName, actionDigest)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		p.stored(instanceName, actionDigest, actionResult, now)
+		return p.failedTTL > 0
+	} else if err != nil {
+		// Only results known to be expired are deleted.
+		logrus.WithError(err).Warnf("failed reading expiry of failed action result")
+		return true
+	}
+	return now.Before(info.ModTime().Add(p.failedTTL))
+}
+
+// expiredFailures returns the failed action results that expired before now, by the name of their file.
+func (p *updatePolicy) expiredFailures(now time.Time) (map[string]*failure, error) {
+	if p.failedResults != FailedResultsExpire {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(p.failuresPath)
+	if err != nil {
+		return nil, err
+	}
+	expired := make(map[string]*failure)
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), tmpFilePrefix) || now.Before(f.ModTime().Add(p.failedTTL)) {
+			continue
+		}
+		fileName := path.Join(p.failuresPath, f.Name())
+		content, err := ioutil.ReadFile(fileName)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		e := &failure{}
+		if err := json.Unmarshal(content, e); err != nil {
+			logrus.WithError(err).Warnf("dropping unreadable expiry of failed action result %v", f.Name())
+			os.Remove(fileName)
+			continue
+		}
+		expired[fileName] = e
+	}
+	return expired, nil
+}
+
+func (p *updatePolicy) trusted(ctx context.Context) bool {
+	if len(p.trustedNetworks) == 0 {
+		return true
+	}
+	tcpAddr, ok := peerAddress(ctx).(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range p.trustedNetworks {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func peerAddress(ctx context.Context) net.Addr {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return p.Addr
+}
+
+// expireFailuresLoop deletes expired results of failed actions until the service is closed, so that the outputs of
+// results that are never requested again can be garbage collected.
+func (l *local) expireFailuresLoop() {
+	interval := l.cfg.FailedResultsTTL
+	if interval < minFailuresExpiryInterval {
+		interval = minFailuresExpiryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			if err := l.deleteExpiredFailures(now); err != nil {
+				logrus.WithError(err).Errorf("deleting expired results of failed actions failed")
+			}
+		}
+	}
+}
+
+// deleteExpiredFailures deletes the results of failed actions that expired before now.
+func (l *local) deleteExpiredFailures(now time.Time) error {
+	expired, err := l.policy.expiredFailures(now)
+	if err != nil {
+		return err
+	}
+	for fileName, f := range expired {
+		store, err := l.stores.Get(f.InstanceName)
+		if err != nil {
+			return err
+		}
+		actionDigest := &remoteexecution.Digest{Hash: f.Hash, SizeBytes: f.SizeBytes}
+		actionResult, err := store.Get(actionDigest)
+		// A success may have replaced the failure since it was listed.
+		if err == nil && actionResult.ExitCode != 0 {
+			err = store.Delete(actionDigest)
+		} else if status.Code(err) == codes.NotFound {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// failureFile returns the name of the file of a stored result of a failed action.
+func (p *updatePolicy) failureFile(instanceName string, actionDigest *remoteexecution.Digest) (string, error) {
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return "", err
+	}
+	if instanceName != "" {
+		// The same action in different instances expires separately.
+		key = url.QueryEscape(instanceName) + "_" + key
+	}
+	return path.Join(p.failuresPath, key), nil
+}
+
+// writeFailure atomically replaces the file of the failed action result, with a modification time of now.
+func writeFailure(fileName string, f *failure, now time.Time) error {
+	content, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(path.Dir(fileName), tmpFilePrefix)
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tmpFile.Name(), now, now)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package actioncache
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func contextFrom(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}})
+}
+
+func TestUpdatePolicy_AllowUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	actionDigest := util.DataToContentDigest(util.SHA256, []byte("action"))
+	for _, tcase := range []struct {
+		name          string
+		failedResults string
+		trusted       []string
+		ctx           context.Context
+		exitCode      int32
+		code          codes.Code
+	}{
+		{name: "success", failedResults: FailedResultsReject, ctx: context.Background(), exitCode: 0, code: codes.OK},
+		{name: "failure_stored", failedResults: FailedResultsStore, ctx: context.Background(), exitCode: 1, code: codes.OK},
+		{name: "failure_rejected", failedResults: FailedResultsReject, ctx: context.Background(), exitCode: 1, code: codes.FailedPrecondition},
+		{name: "failure_expiring", failedResults: FailedResultsExpire, ctx: context.Background(), exitCode: 1, code: codes.OK},
+		{name: "trusted", failedResults: FailedResultsStore, trusted: []string{"10.0.0.0/8"}, ctx: contextFrom("10.1.2.3"), code: codes.OK},
+		{name: "untrusted", failedResults: FailedResultsStore, trusted: []string{"10.0.0.0/8"}, ctx: contextFrom("192.168.1.1"), code: codes.PermissionDenied},
+		{name: "untrusted_no_peer", failedResults: FailedResultsStore, trusted: []string{"10.0.0.0/8"}, ctx: context.Background(), code: codes.PermissionDenied},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			p, err := newUpdatePolicy(tcase.failedResults, time.Minute, tcase.trusted, dir)
+			require.NoError(t, err)
+			err = p.allowUpdate(tcase.ctx, &remoteexecution.UpdateActionResultRequest{
+				ActionDigest: actionDigest,
+				ActionResult: &remoteexecution.ActionResult{ExitCode: tcase.exitCode},
+			})
+			assert.Equal(t, tcase.code, status.Code(err))
+		})
+	}
+}
+
+func TestUpdatePolicy_FailuresExpire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	actionDigest := util.DataToContentDigest(util.SHA256, []byte("action"))
+	failed := &remoteexecution.ActionResult{ExitCode: 1}
+	p, err := newUpdatePolicy(FailedResultsExpire, time.Minute, nil, dir)
+	require.NoError(t, err)
+	now := time.Now()
+
+	p.stored("", actionDigest, failed, now)
+	restarted, err := newUpdatePolicy(FailedResultsExpire, time.Minute, nil, dir)
+	require.NoError(t, err)
+	assert.True(t, restarted.servable("", actionDigest, failed, now.Add(30*time.Second)), "expiry must survive restarts")
+	assert.False(t, restarted.servable("", actionDigest, failed, now.Add(2*time.Minute)))
+	assert.True(t, p.servable("", actionDigest, &remoteexecution.ActionResult{}, now.Add(2*time.Minute)), "successes never expire")
+
+	assert.True(t, p.servable("other", actionDigest, failed, now.Add(2*time.Minute)), "unknown failures must be served")
+	assert.False(t, p.servable("other", actionDigest, failed, now.Add(4*time.Minute)), "unknown failures must expire once seen")
+
+	expired, err := p.expiredFailures(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, expired, 1, "only the failure stored first should have expired")
+	p.stored("", actionDigest, &remoteexecution.ActionResult{}, now.Add(3*time.Minute))
+	expired, err = p.expiredFailures(now.Add(10 * time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, expired, 1, "a success must replace the expiring failure")
+}
+
+func TestDeleteExpiredFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	policy, err := newUpdatePolicy(FailedResultsExpire, time.Minute, nil, dir)
+	require.NoError(t, err)
+	store := action.NewInMemory()
+	l := &local{
+		cfg:    Config{UpdateEnabled: true},
+		stores: action.NewPerInstance(func(string) (action.Store, error) { return store, nil }),
+		policy: policy,
+	}
+	stale := util.DataToContentDigest(util.SHA256, []byte("stale"))
+	fresh := util.DataToContentDigest(util.SHA256, []byte("fresh"))
+	for _, actionDigest := range []*remoteexecution.Digest{stale, fresh} {
+		_, err := l.UpdateActionResult(context.Background(), &remoteexecution.UpdateActionResultRequest{
+			ActionDigest: actionDigest,
+			ActionResult: &remoteexecution.ActionResult{ExitCode: 1},
+		})
+		require.NoError(t, err)
+	}
+	now := time.Now()
+	staleFile, err := policy.failureFile("", stale)
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(staleFile, now.Add(-2*time.Minute), now.Add(-2*time.Minute)))
+
+	require.NoError(t, l.deleteExpiredFailures(now))
+	_, err = store.Get(stale)
+	assert.Equal(t, codes.NotFound, status.Code(err), "expired failure must be deleted without being requested")
+	_, err = store.Get(fresh)
+	assert.NoError(t, err, "failure that didn't expire must be kept")
+}
@@ -0,0 +1,48 @@
+package actioncache
+
+import (
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// readThrough looks up the action in the upstream cache and stores it locally if found.
+// Upstream failures are treated as misses, as an unavailable upstream shouldn't fail builds.
+func (l *local) readThrough(ctx context.Context, store action.Store, req *remoteexecution.GetActionResultRequest) (*remoteexecution.ActionResult, error) {
+	actionResult, err := l.upstream.GetActionResult(ctx, req)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			logrus.WithError(err).Warnf("upstream GetActionResult failed, treating as a miss")
+		}
+		return nil, status.Errorf(codes.NotFound, "action doesnt exist")
+	}
+	if err := store.Store(req.ActionDigest, actionResult); err != nil {
+		logrus.WithError(err).Warnf("failed storing upstream action locally")
+		return actionResult, nil
+	}
+	l.policy.stored(req.InstanceName, req.ActionDigest, actionResult, time.Now())
+	return actionResult, nil
+}
+
+// writeBack replicates a locally stored action to the upstream cache, used by the writeback queue.
+func (l *local) writeBack(ctx context.Context, instanceName string, actionDigest *remoteexecution.Digest) error {
+	store, err := l.stores.Get(instanceName)
+	if err != nil {
+		return err
+	}
+	actionResult, err := store.Get(actionDigest)
+	if err != nil {
+		return err
+	}
+	_, err = l.upstream.UpdateActionResult(ctx, &remoteexecution.UpdateActionResultRequest{
+		InstanceName: instanceName,
+		ActionDigest: actionDigest,
+		ActionResult: actionResult,
+	})
+	return err
+}
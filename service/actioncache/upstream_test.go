@@ -0,0 +1,162 @@
+package actioncache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/common/writeback"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeUpstream is an upstream ActionCache that runs the update policy of a distcache, or fails every lookup with err.
+type fakeUpstream struct {
+	policy  *updatePolicy
+	calls   chan *remoteexecution.UpdateActionResultRequest
+	results map[string]*remoteexecution.ActionResult
+	err     error
+}
+
+func (f *fakeUpstream) GetActionResult(ctx context.Context, req *remoteexecution.GetActionResultRequest, opts ...grpc.CallOption) (*remoteexecution.ActionResult, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if result, ok := f.results[req.ActionDigest.Hash]; ok {
+		return result, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "action doesnt exist")
+}
+
+func (f *fakeUpstream) UpdateActionResult(ctx context.Context, req *remoteexecution.UpdateActionResultRequest, opts ...grpc.CallOption) (*remoteexecution.ActionResult, error) {
+	f.calls <- req
+	if err := f.policy.allowUpdate(ctx, req); err != nil {
+		return nil, err
+	}
+	return req.ActionResult, nil
+}
+
+func waitForEmptyDir(t *testing.T, dir string) {
+	for i := 0; i < 100; i++ {
+		files, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		if len(files) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("queue directory %v was never emptied", dir)
+}
+
+func TestWriteBack_DropsResultsRejectedUpstream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writeback_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, tcase := range []struct {
+		name    string
+		trusted []string
+	}{
+		{name: "failed_action"},
+		{name: "untrusted", trusted: []string{"10.0.0.0/8"}},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			upstreamPolicy, err := newUpdatePolicy(FailedResultsReject, 0, tcase.trusted, "")
+			require.NoError(t, err)
+			upstream := &fakeUpstream{policy: upstreamPolicy, calls: make(chan *remoteexecution.UpdateActionResultRequest, 10)}
+			policy, err := newUpdatePolicy(FailedResultsStore, 0, nil, "")
+			require.NoError(t, err)
+			store := action.NewInMemory()
+			l := &local{
+				cfg:      Config{UpdateEnabled: true},
+				stores:   action.NewPerInstance(func(string) (action.Store, error) { return store, nil }),
+				policy:   policy,
+				upstream: upstream,
+			}
+			// Retries would only happen after an hour, so the queue only drains if the rejection is permanent.
+			l.writeback, err = writeback.New(writeback.Config{Path: dir, Concurrency: 1, MaxBackoff: time.Hour, UploadTimeout: time.Second}, tcase.name, l.writeBack)
+			require.NoError(t, err)
+
+			_, err = l.UpdateActionResult(context.Background(), &remoteexecution.UpdateActionResultRequest{
+				ActionDigest: util.DataToContentDigest(util.SHA256, []byte("action")),
+				ActionResult: &remoteexecution.ActionResult{ExitCode: 1},
+			})
+			require.NoError(t, err)
+			<-upstream.calls
+			waitForEmptyDir(t, dir+"/"+tcase.name)
+		})
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	stored := util.DataToContentDigest(util.SHA256, []byte("action"))
+	missing := util.DataToContentDigest(util.SHA256, []byte("missing action"))
+	results := map[string]*remoteexecution.ActionResult{stored.Hash: {ExitCode: 0, StdoutRaw: []byte("hello")}}
+
+	for _, tcase := range []struct {
+		name     string
+		digest   *remoteexecution.Digest
+		upstream *fakeUpstream
+		code     codes.Code
+	}{
+		{name: "hit", digest: stored, upstream: &fakeUpstream{results: results}},
+		{name: "not_found", digest: missing, upstream: &fakeUpstream{results: results}, code: codes.NotFound},
+		{name: "unavailable", digest: stored, upstream: &fakeUpstream{err: status.Errorf(codes.Unavailable, "upstream is down")}, code: codes.NotFound},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			policy, err := newUpdatePolicy(FailedResultsStore, 0, nil, "")
+			require.NoError(t, err)
+			store := action.NewInMemory()
+			l := &local{
+				stores:   action.NewPerInstance(func(string) (action.Store, error) { return store, nil }),
+				blobs:    &fakeBlobs{},
+				policy:   policy,
+				upstream: tcase.upstream,
+			}
+
+			actionResult, err := l.GetActionResult(context.Background(), &remoteexecution.GetActionResultRequest{ActionDigest: tcase.digest})
+			assert.Equal(t, tcase.code, status.Code(err), "unexpected error: %v", err)
+			_, storeErr := store.Get(tcase.digest)
+			if tcase.code == codes.OK {
+				assert.Equal(t, results[stored.Hash], actionResult)
+				assert.NoError(t, storeErr, "hit upstream should be stored locally")
+			} else {
+				assert.Equal(t, codes.NotFound, status.Code(storeErr), "misses shouldn't be stored locally")
+			}
+		})
+	}
+}
+
+func TestReadThrough_FailuresExpireLocally(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	actionDigest := util.DataToContentDigest(util.SHA256, []byte("action"))
+	policy, err := newUpdatePolicy(FailedResultsExpire, time.Minute, nil, dir)
+	require.NoError(t, err)
+	store := action.NewInMemory()
+	l := &local{
+		stores:   action.NewPerInstance(func(string) (action.Store, error) { return store, nil }),
+		blobs:    &fakeBlobs{},
+		policy:   policy,
+		upstream: &fakeUpstream{results: map[string]*remoteexecution.ActionResult{actionDigest.Hash: {ExitCode: 1}}},
+	}
+
+	for i := 0; i < 2; i++ {
+		actionResult, err := l.GetActionResult(context.Background(), &remoteexecution.GetActionResultRequest{ActionDigest: actionDigest})
+		require.NoError(t, err, "failure read through from upstream must be served until it expires")
+		assert.EqualValues(t, 1, actionResult.ExitCode)
+	}
+	expired, err := policy.expiredFailures(time.Now().Add(2 * time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, expired, 1, "failure read through from upstream must expire")
+}
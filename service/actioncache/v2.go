@@ -0,0 +1,48 @@
+package actioncache
+
+import (
+	remoteexecution_v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+)
+
+// localV2 serves the REAPI v2 ActionCache from the same stores as the v1test one.
+// Inlining of outputs requested by v2 clients is optional for servers, and is not done.
+type localV2 struct {
+	l *local
+}
+
+func (l *local) V2() remoteexecution_v2.ActionCacheServer {
+	return &localV2{l: l}
+}
+
+func (v *localV2) GetActionResult(ctx context.Context, req *remoteexecution_v2.GetActionResultRequest) (*remoteexecution_v2.ActionResult, error) {
+	actionResult, err := v.l.GetActionResult(ctx, &remoteexecution.GetActionResultRequest{
+		InstanceName: req.InstanceName,
+		ActionDigest: util.DigestFromV2(req.ActionDigest),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return util.ActionResultToV2(actionResult)
+}
+
+func (v *localV2) UpdateActionResult(ctx context.Context, req *remoteexecution_v2.UpdateActionResultRequest) (*remoteexecution_v2.ActionResult, error) {
+	var actionResult *remoteexecution.ActionResult
+	if req.ActionResult != nil {
+		var err error
+		if actionResult, err = util.ActionResultFromV2(req.ActionResult); err != nil {
+			return nil, err
+		}
+	}
+	_, err := v.l.UpdateActionResult(ctx, &remoteexecution.UpdateActionResultRequest{
+		InstanceName: req.InstanceName,
+		ActionDigest: util.DigestFromV2(req.ActionDigest),
+		ActionResult: actionResult,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return req.ActionResult, nil
+}
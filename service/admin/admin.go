@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/proto/distcache/admin"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// New builds the Admin gRPC service, operating on the same stores as the cache services.
+func New(blobStores *blob.PerInstance, actionStores *action.PerInstance) distcache_admin.AdminServer {
+	return &admin{blobStores: blobStores, actionStores: actionStores}
+}
+
+type admin struct {
+	blobStores   *blob.PerInstance
+	actionStores *action.PerInstance
+}
+
+func (a *admin) GetActionResult(ctx context.Context, req *distcache_admin.GetActionResultRequest) (*remoteexecution.ActionResult, error) {
+	if req.ActionDigest == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "action digest must be set")
+	}
+	store, err := a.actionStores.Get(req.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+	return store.Get(req.ActionDigest)
+}
+
+func (a *admin) DeleteActionResult(ctx context.Context, req *distcache_admin.DeleteActionResultRequest) (*distcache_admin.DeleteActionResultResponse, error) {
+	if req.ActionDigest == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "action digest must be set")
+	}
+	store, err := a.actionStores.Get(req.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Delete(req.ActionDigest); err != nil {
+		return nil, err
+	}
+	log.WithField("action", req.ActionDigest.Hash).Infof("admin deleted action result of instance %q", req.InstanceName)
+	return &distcache_admin.DeleteActionResultResponse{}, nil
+}
+
+func (a *admin) DeleteBlob(ctx context.Context, req *distcache_admin.DeleteBlobRequest) (*distcache_admin.DeleteBlobResponse, error) {
+	if req.Digest == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "digest must be set")
+	}
+	store, err := a.blobStores.Get(req.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Delete(ctx, req.Digest); err != nil {
+		return nil, err
+	}
+	log.WithField("blob", req.Digest.Hash).Infof("admin deleted blob of instance %q", req.InstanceName)
+	return &distcache_admin.DeleteBlobResponse{}, nil
+}
+
+func (a *admin) GetStats(ctx context.Context, req *distcache_admin.GetStatsRequest) (*distcache_admin.GetStatsResponse, error) {
+	blobStore, err := a.blobStores.Get(req.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+	actionStore, err := a.actionStores.Get(req.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+	resp := &distcache_admin.GetStatsResponse{}
+	err = blobStore.Walk(ctx, func(blobDigest *remoteexecution.Digest, _ time.Time) error {
+		resp.Blobs++
+		resp.BlobBytes += blobDigest.SizeBytes
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, status.Convert(err).Err()
+	}
+	err = actionStore.Walk(func(*remoteexecution.Digest, *remoteexecution.ActionResult) error {
+		resp.ActionResults++
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, status.Convert(err).Err()
+	}
+	return resp, nil
+}
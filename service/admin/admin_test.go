@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mwitkow/bazel-distcache/proto/distcache/admin"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	helloHash = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+)
+
+func TestAdmin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	blobBackend, err := blob.NewOnDiskBackend(dir, blob.OnDiskConfig{})
+	require.NoError(t, err)
+	blobStores := blob.NewPerInstanceForBackend(blobBackend)
+	actionBackend, err := action.OpenBackend("mem://")
+	require.NoError(t, err)
+	actionStores := action.NewPerInstanceForBackend(actionBackend)
+	server := New(blobStores, actionStores)
+
+	blobDigest := &remoteexecution.Digest{Hash: helloHash, SizeBytes: 11}
+	blobStore, err := blobStores.Get("")
+	require.NoError(t, err)
+	writer, err := blobStore.Write(ctx, blobDigest)
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	actionDigest := &remoteexecution.Digest{Hash: "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"}
+	actionStore, err := actionStores.Get("")
+	require.NoError(t, err)
+	require.NoError(t, actionStore.Store(actionDigest, &remoteexecution.ActionResult{StdoutDigest: blobDigest}))
+
+	stats, err := server.GetStats(ctx, &distcache_admin.GetStatsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, &distcache_admin.GetStatsResponse{ActionResults: 1, Blobs: 1, BlobBytes: 11}, stats)
+
+	actionResult, err := server.GetActionResult(ctx, &distcache_admin.GetActionResultRequest{ActionDigest: actionDigest})
+	require.NoError(t, err)
+	assert.Equal(t, helloHash, actionResult.StdoutDigest.Hash)
+
+	_, err = server.DeleteActionResult(ctx, &distcache_admin.DeleteActionResultRequest{ActionDigest: actionDigest})
+	require.NoError(t, err)
+	_, err = server.GetActionResult(ctx, &distcache_admin.GetActionResultRequest{ActionDigest: actionDigest})
+	assert.Equal(t, codes.NotFound, status.Code(err), "deleted action result must be NotFound")
+	_, err = server.DeleteBlob(ctx, &distcache_admin.DeleteBlobRequest{Digest: blobDigest})
+	require.NoError(t, err)
+	stats, err = server.GetStats(ctx, &distcache_admin.GetStatsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, &distcache_admin.GetStatsResponse{}, stats, "deleted contents must not be counted")
+}
@@ -0,0 +1,52 @@
+package capabilities
+
+import (
+	remoteexecution_v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/bazelbuild/remote-apis/build/bazel/semver"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/service/actioncache"
+	"github.com/mwitkow/bazel-distcache/service/cas"
+	"golang.org/x/net/context"
+)
+
+var (
+	// apiVersion is the version of the REAPI v2 protos the services are built against.
+	apiVersion = &semver.SemVer{Major: 2}
+)
+
+// New builds the REAPI v2 Capabilities gRPC service, describing the configuration of the given services to bazel.
+//
+// Blobs are always stored and served uncompressed. The REAPI v2 protos the services are built against predate
+// compressor negotiation, so no compressors are advertised and clients stick to the identity encoding.
+func New(casServer cas.ConcreteCaSServer, actionCacheServer actioncache.ConcreteActionCacheServer) remoteexecution_v2.CapabilitiesServer {
+	return &capabilities{casServer: casServer, actionCacheServer: actionCacheServer}
+}
+
+type capabilities struct {
+	casServer         cas.ConcreteCaSServer
+	actionCacheServer actioncache.ConcreteActionCacheServer
+}
+
+func (c *capabilities) GetCapabilities(ctx context.Context, req *remoteexecution_v2.GetCapabilitiesRequest) (*remoteexecution_v2.ServerCapabilities, error) {
+	return &remoteexecution_v2.ServerCapabilities{
+		CacheCapabilities: &remoteexecution_v2.CacheCapabilities{
+			DigestFunction: digestFunctions(),
+			ActionCacheUpdateCapabilities: &remoteexecution_v2.ActionCacheUpdateCapabilities{
+				UpdateEnabled: c.actionCacheServer.UpdateEnabled(),
+			},
+			MaxBatchTotalSizeBytes: c.casServer.MaxBatchTotalSizeBytes(),
+		},
+		LowApiVersion:  apiVersion,
+		HighApiVersion: apiVersion,
+	}, nil
+}
+
+// digestFunctions returns the digest functions enabled by flags, most preferred first.
+func digestFunctions() []remoteexecution_v2.DigestFunction_Value {
+	var ret []remoteexecution_v2.DigestFunction_Value
+	for _, f := range util.SupportedDigestFunctions() {
+		// Names of digest functions are the same as in the REAPI enum.
+		ret = append(ret, remoteexecution_v2.DigestFunction_Value(remoteexecution_v2.DigestFunction_Value_value[f.Name]))
+	}
+	return ret
+}
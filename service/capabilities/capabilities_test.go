@@ -0,0 +1,43 @@
+package capabilities
+
+import (
+	"testing"
+
+	remoteexecution_v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/mwitkow/bazel-distcache/service/actioncache"
+	"github.com/mwitkow/bazel-distcache/service/cas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type fakeCas struct {
+	cas.ConcreteCaSServer
+	maxBatchTotalSizeBytes int64
+}
+
+func (f *fakeCas) MaxBatchTotalSizeBytes() int64 {
+	return f.maxBatchTotalSizeBytes
+}
+
+type fakeActionCache struct {
+	actioncache.ConcreteActionCacheServer
+	updateEnabled bool
+}
+
+func (f *fakeActionCache) UpdateEnabled() bool {
+	return f.updateEnabled
+}
+
+func TestGetCapabilities(t *testing.T) {
+	for _, updateEnabled := range []bool{true, false} {
+		server := New(&fakeCas{maxBatchTotalSizeBytes: 1024}, &fakeActionCache{updateEnabled: updateEnabled})
+		resp, err := server.GetCapabilities(context.Background(), &remoteexecution_v2.GetCapabilitiesRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, []remoteexecution_v2.DigestFunction_Value{remoteexecution_v2.DigestFunction_SHA256, remoteexecution_v2.DigestFunction_SHA1},
+			resp.CacheCapabilities.DigestFunction, "default digest functions must be reported, most preferred first")
+		assert.EqualValues(t, 1024, resp.CacheCapabilities.MaxBatchTotalSizeBytes)
+		assert.Equal(t, updateEnabled, resp.CacheCapabilities.ActionCacheUpdateCapabilities.UpdateEnabled)
+		assert.EqualValues(t, 2, resp.HighApiVersion.Major)
+	}
+}
@@ -1,9 +1,11 @@
 package cas
 
 import (
+	"fmt"
 	"io"
+	"time"
 
-	"github.com/mwitkow/bazel-distcache/common/sharedflags"
+	"github.com/mwitkow/bazel-distcache/common/writeback"
 	"github.com/mwitkow/bazel-distcache/stores/blob"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
@@ -11,43 +13,87 @@ import (
 
 	"io/ioutil"
 
+	remoteexecution_v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/proto/distcache/cas"
 	"google.golang.org/genproto/googleapis/bytestream"
 	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/status"
 )
 
-var (
-	chunkSizeBytes = sharedflags.Set.Int("casservice_local_chunk_size_bytes",
-		2*1024*1024,
-		"Size of chunk streamed down to bazel clients. Can be max 4MB due to gRPC limits.")
-)
+// Config configures the CaS service.
+type Config struct {
+	// ChunkSizeBytes is the size of chunks streamed down to clients. Can be max 4MB due to gRPC limits.
+	ChunkSizeBytes int
+	// UploadResumptionTimeout is the time for which interrupted ByteStream uploads can be resumed before being
+	// discarded. Uploads can't be resumed if 0.
+	UploadResumptionTimeout time.Duration
+	// BatchMaxBytes is the maximum total size of blobs read or updated in a single batch. Can be max 4MB due to gRPC
+	// limits.
+	BatchMaxBytes int64
+	// Writeback configures the queue of blobs replicated to upstream, if there is one.
+	Writeback writeback.Config
+}
 
 // ConcreteCasServer is a combined implementation of the ByteStreamServer and the ContentAddressableStorageServer.
 type ConcreteCaSServer interface {
 	remoteexecution.ContentAddressableStorageServer
 	bytestream.ByteStreamServer
+	distcache_cas.ContentAddressableStorageExtensionsServer
+
+	// V2 returns the REAPI v2 ContentAddressableStorageServer backed by the same stores.
+	V2() remoteexecution_v2.ContentAddressableStorageServer
+	// MaxBatchTotalSizeBytes returns the maximum total size of blobs in a single batch read or update.
+	MaxBatchTotalSizeBytes() int64
+	// Close stops the background work of the service.
+	Close()
 }
 
-// NewLocal builds the CaS gRPC service for local daemon.
-func NewLocal() ConcreteCaSServer {
-	store, err := blob.NewOnDisk()
-	if err != nil {
-		log.Fatalf("could not initialise CaSService: %v", err)
+// NewLocal builds the CaS gRPC service for local daemon, serving blobs of the stores.
+// If upstream is not nil, blobs missing locally are looked up in the upstream cache and stored locally when read, and
+// blobs written locally are replicated to it in the background.
+func NewLocal(cfg Config, stores blob.Stores, upstream *grpc.ClientConn) (ConcreteCaSServer, error) {
+	// Make sure the default instance's store can be initialised, as the service is useless otherwise.
+	if _, err := stores.Get(""); err != nil {
+		return nil, fmt.Errorf("could not initialise CaSService: %v", err)
 	}
-	return &local{store}
+	l := &local{cfg: cfg, stores: stores, uploads: newUploads(cfg.UploadResumptionTimeout)}
+	if upstream != nil {
+		var err error
+		l.upstreamCas = remoteexecution.NewContentAddressableStorageClient(upstream)
+		l.upstreamByteStream = bytestream.NewByteStreamClient(upstream)
+		l.writeback, err = writeback.New(cfg.Writeback, "blobs", l.writeBack)
+		if err != nil {
+			return nil, fmt.Errorf("could not initialise CaSService writeback: %v", err)
+		}
+	}
+	return l, nil
 }
 
 // local implements both the ContentAddressableStorageService and the BlobStreamService
 type local struct {
-	store blob.Store
+	cfg     Config
+	stores  blob.Stores
+	uploads *uploads
+
+	upstreamCas        remoteexecution.ContentAddressableStorageClient
+	upstreamByteStream bytestream.ByteStreamClient
+	writeback          *writeback.Queue
+}
+
+func (l *local) Close() {
+	l.uploads.close()
 }
 
 func (l *local) FindMissingBlobs(ctx context.Context, req *remoteexecution.FindMissingBlobsRequest) (*remoteexecution.FindMissingBlobsResponse, error) {
-	// TODO(mwitkow): Handle instance name of the request resourceName
+	store, err := l.stores.Get(req.InstanceName)
+	if err != nil {
+		return nil, err
+	}
 	resp := &remoteexecution.FindMissingBlobsResponse{}
 	for _, blobDigest := range req.BlobDigests {
-		exists, err := l.store.Exists(ctx, blobDigest)
+		exists, err := store.Exists(ctx, blobDigest)
 		if err != nil {
 			return nil, err
 		}
@@ -55,11 +101,104 @@ func (l *local) FindMissingBlobs(ctx context.Context, req *remoteexecution.FindM
 			resp.MissingBlobDigests = append(resp.MissingBlobDigests, blobDigest)
 		}
 	}
+	if len(resp.MissingBlobDigests) > 0 && l.upstreamCas != nil {
+		resp.MissingBlobDigests = l.findMissingUpstream(ctx, req.InstanceName, resp.MissingBlobDigests)
+	}
 	return resp, nil
 }
 
-func (l *local) BatchUpdateBlobs(context.Context, *remoteexecution.BatchUpdateBlobsRequest) (*remoteexecution.BatchUpdateBlobsResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "BatchUpdateBlobs is not used in bazel 0.5.3, ignore for now.")
+func (l *local) BatchUpdateBlobs(ctx context.Context, req *remoteexecution.BatchUpdateBlobsRequest) (*remoteexecution.BatchUpdateBlobsResponse, error) {
+	store, err := l.stores.Get(req.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+	totalBytes := int64(0)
+	for _, blobReq := range req.Requests {
+		totalBytes += int64(len(blobReq.Data))
+	}
+	if totalBytes > l.MaxBatchTotalSizeBytes() {
+		return nil, status.Errorf(codes.InvalidArgument, "batch of %d bytes is larger than the max of %d bytes, upload blobs through ByteStream", totalBytes, l.MaxBatchTotalSizeBytes())
+	}
+	resp := &remoteexecution.BatchUpdateBlobsResponse{}
+	for _, blobReq := range req.Requests {
+		if blobReq.ContentDigest == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "content digest must be set for all blobs")
+		}
+		// Failures of individual blobs are reported in their status, and don't fail the whole batch.
+		err := updateBlob(ctx, store, blobReq.ContentDigest, blobReq.Data)
+		if err == nil && l.writeback != nil {
+			if err := l.writeback.Enqueue(req.InstanceName, blobReq.ContentDigest); err != nil {
+				log.WithError(err).Errorf("failed enqueuing blob for upstream writeback")
+			}
+		}
+		resp.Responses = append(resp.Responses, &remoteexecution.BatchUpdateBlobsResponse_Response{
+			BlobDigest: blobReq.ContentDigest,
+			Status:     status.Convert(err).Proto(),
+		})
+	}
+	return resp, nil
+}
+
+// updateBlob verifies the data against the digest and writes it into the store.
+func updateBlob(ctx context.Context, store blob.Store, blobDigest *remoteexecution.Digest, data []byte) error {
+	if err := util.VerifyContentDigest(blobDigest, data); err != nil {
+		return err
+	}
+	blobWriter, err := store.Write(ctx, blobDigest)
+	if err != nil {
+		return err
+	}
+	if _, err := blobWriter.Write(data); err != nil {
+		blobWriter.Close()
+		return status.Convert(err).Err()
+	}
+	return status.Convert(blobWriter.Close()).Err()
+}
+
+func (l *local) BatchReadBlobs(ctx context.Context, req *distcache_cas.BatchReadBlobsRequest) (*distcache_cas.BatchReadBlobsResponse, error) {
+	store, err := l.stores.Get(req.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+	resp := &distcache_cas.BatchReadBlobsResponse{}
+	remainingBytes := l.MaxBatchTotalSizeBytes()
+	for _, blobDigest := range req.Digests {
+		data, err := readBlob(ctx, store, blobDigest, remainingBytes)
+		remainingBytes -= int64(len(data))
+		// Failures of individual blobs are reported in their status, and don't fail the whole batch.
+		resp.Responses = append(resp.Responses, &distcache_cas.BatchReadBlobsResponse_Response{
+			Digest: blobDigest,
+			Data:   data,
+			Status: status.Convert(err).Proto(),
+		})
+	}
+	return resp, nil
+}
+
+func (l *local) MaxBatchTotalSizeBytes() int64 {
+	return l.cfg.BatchMaxBytes
+}
+
+// readBlob reads the whole blob into memory, as long as it is not larger than maxBytes.
+func readBlob(ctx context.Context, store blob.Store, blobDigest *remoteexecution.Digest, maxBytes int64) ([]byte, error) {
+	if blobDigest.SizeBytes > maxBytes {
+		return nil, status.Errorf(codes.ResourceExhausted, "blob doesn't fit in the batch, read it through ByteStream")
+	}
+	blobReader, err := store.Read(ctx, blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	defer blobReader.Close()
+	// The size of the stored blob may differ from the one requested.
+	size := blobReader.Digest().SizeBytes
+	if size > maxBytes {
+		return nil, status.Errorf(codes.ResourceExhausted, "blob doesn't fit in the batch, read it through ByteStream")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(blobReader, data); err != nil {
+		return nil, status.Errorf(codes.DataLoss, "cannot read this file %v", err)
+	}
+	return data, nil
 }
 
 func (l *local) GetTree(context.Context, *remoteexecution.GetTreeRequest) (*remoteexecution.GetTreeResponse, error) {
@@ -67,12 +206,18 @@ func (l *local) GetTree(context.Context, *remoteexecution.GetTreeRequest) (*remo
 }
 
 func (l *local) Read(req *bytestream.ReadRequest, readStream bytestream.ByteStream_ReadServer) error {
-	// TODO(mwitkow): Handle instance name of the request resourceName
 	blobDigest, err := util.ResourcePathToContentDigest(req.ResourceName)
 	if err != nil {
 		return err
 	}
-	blobReader, err := l.store.Read(readStream.Context(), blobDigest)
+	store, err := l.stores.Get(util.ResourcePathToInstanceName(req.ResourceName))
+	if err != nil {
+		return err
+	}
+	blobReader, err := store.Read(readStream.Context(), blobDigest)
+	if status.Code(err) == codes.NotFound && l.upstreamByteStream != nil {
+		return l.readThrough(req, readStream, store, blobDigest)
+	}
 	if err != nil {
 		// Store returns gRPC error codes, including not found.
 		return err
@@ -81,6 +226,7 @@ func (l *local) Read(req *bytestream.ReadRequest, readStream bytestream.ByteStre
 	if req.ReadOffset > blobReader.Digest().SizeBytes {
 		return status.Errorf(codes.OutOfRange, "read offset larger than blob size")
 	}
+	setBlobSizeHeader(readStream, blobReader.Digest().SizeBytes)
 	if req.ReadOffset > 0 {
 		if _, err := io.CopyN(ioutil.Discard, blobReader, req.ReadOffset); err != nil {
 			return status.Errorf(codes.Internal, "failed seeking to offset")
@@ -88,7 +234,7 @@ func (l *local) Read(req *bytestream.ReadRequest, readStream bytestream.ByteStre
 	}
 	for {
 		// TODO(mwitkow): This allocates a lot, try moving it to the top.
-		chunkBuffer := make([]byte, *chunkSizeBytes)
+		chunkBuffer := make([]byte, l.cfg.ChunkSizeBytes)
 		n, readErr := blobReader.Read(chunkBuffer)
 		if readErr != nil && readErr != io.EOF {
 			if statusErr, ok := status.FromError(readErr); ok {
@@ -109,26 +255,43 @@ func (l *local) Read(req *bytestream.ReadRequest, readStream bytestream.ByteStre
 	return nil
 }
 
-func (l *local) Write(writeStream bytestream.ByteStream_WriteServer) error {
+func (l *local) Write(writeStream bytestream.ByteStream_WriteServer) (err error) {
 	firstMsg, err := writeStream.Recv()
 	if err != nil {
 		return err
 	}
-	// TODO(mwitkow): Handle instance name of the request resourceName
 	blobDigest, err := util.ResourcePathToContentDigest(firstMsg.ResourceName)
 	if err != nil {
 		return err
 	}
-	if firstMsg.WriteOffset > 0 {
-		// TODO(mwitkow): Implement this write resumption. According to the docs, returning NotFound should be safe.
-		return status.Errorf(codes.Unimplemented, "write resumption hasn't been implemented")
+	instanceName := util.ResourcePathToInstanceName(firstMsg.ResourceName)
+	store, err := l.stores.Get(instanceName)
+	if err != nil {
+		return err
+	}
+	up, err := l.startOrResumeUpload(writeStream.Context(), store, firstMsg, blobDigest)
+	if err != nil {
+		return err
 	}
-	blobWriter, err := l.store.Write(writeStream.Context(), blobDigest)
-	defer blobWriter.Close()
+	finished := false
+	defer func() {
+		if finished {
+			return
+		}
+		if status.Code(err) == codes.InvalidArgument {
+			// The data can't match the digest anymore, there's no point resuming.
+			l.uploads.finish(firstMsg.ResourceName, up)
+		} else {
+			l.uploads.suspend(firstMsg.ResourceName, up)
+		}
+	}()
 	writeChunk := firstMsg
 	for true {
+		if up.committedSize+int64(len(writeChunk.Data)) > blobDigest.SizeBytes {
+			return status.Errorf(codes.InvalidArgument, "received more data than the %d bytes of the digest", blobDigest.SizeBytes)
+		}
 		if len(writeChunk.Data) > 0 {
-			n, writeErr := blobWriter.Write(writeChunk.Data)
+			n, writeErr := up.writer.Write(writeChunk.Data)
 			if n != len(writeChunk.Data) {
 				return status.Errorf(codes.Internal, "bad writer implementation, wrote partially %d of %d", n, len(writeChunk.Data))
 			}
@@ -139,6 +302,7 @@ func (l *local) Write(writeStream bytestream.ByteStream_WriteServer) error {
 					return status.Errorf(codes.DataLoss, "cannot read this file %v", writeErr)
 				}
 			}
+			l.uploads.commit(up, n)
 		}
 		if writeChunk.FinishWrite == true {
 			break
@@ -147,16 +311,66 @@ func (l *local) Write(writeStream bytestream.ByteStream_WriteServer) error {
 		writeChunk, err = writeStream.Recv()
 		if err != nil {
 			if err == io.EOF {
-				return status.Errorf(codes.Unimplemented, "received an EOF without FinishWrite, resumption is not supported")
+				// The client closed the stream without finishing, it can resume from the committed size.
+				return writeStream.SendAndClose(&bytestream.WriteResponse{CommittedSize: up.committedSize})
 			} else {
 				return err
 			}
 		}
 	}
-	return nil
+	finished = true
+	// The store verifies the content on Close, and discards the blob if it doesn't match the digest.
+	if err := l.uploads.finish(firstMsg.ResourceName, up); err != nil {
+		if statusErr, ok := status.FromError(err); ok {
+			return statusErr.Err()
+		}
+		return status.Errorf(codes.Internal, "cannot close blob %v", err)
+	}
+	if l.writeback != nil {
+		if err := l.writeback.Enqueue(instanceName, blobDigest); err != nil {
+			// The blob is stored locally, so don't fail the build over it.
+			log.WithError(err).Errorf("failed enqueuing blob for upstream writeback")
+		}
+	}
+	return writeStream.SendAndClose(&bytestream.WriteResponse{CommittedSize: up.committedSize})
+}
+
+// startOrResumeUpload returns the upload to write into: a new one if writing from the start, or a suspended one
+// if the write continues from where it left off.
+func (l *local) startOrResumeUpload(ctx context.Context, store blob.Store, firstMsg *bytestream.WriteRequest, blobDigest *remoteexecution.Digest) (*upload, error) {
+	if firstMsg.WriteOffset > 0 {
+		return l.uploads.resume(firstMsg.ResourceName, firstMsg.WriteOffset)
+	}
+	blobWriter, err := store.Write(ctx, blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	up, err := l.uploads.start(firstMsg.ResourceName, blobWriter)
+	if err != nil {
+		blobWriter.Close()
+		return nil, err
+	}
+	return up, nil
 }
 
-func (l *local) QueryWriteStatus(context.Context, *bytestream.QueryWriteStatusRequest) (*bytestream.QueryWriteStatusResponse, error) {
-	// TODO(mwitkow): Implement this write resumption. According to the docs, returning NotFound should be safe.
-	return nil, status.Errorf(codes.NotFound, "write resumption is not supported")
+func (l *local) QueryWriteStatus(ctx context.Context, req *bytestream.QueryWriteStatusRequest) (*bytestream.QueryWriteStatusResponse, error) {
+	if committedSize, ok := l.uploads.committedSize(req.ResourceName); ok {
+		return &bytestream.QueryWriteStatusResponse{CommittedSize: committedSize}, nil
+	}
+	blobDigest, err := util.ResourcePathToContentDigest(req.ResourceName)
+	if err != nil {
+		return nil, err
+	}
+	store, err := l.stores.Get(util.ResourcePathToInstanceName(req.ResourceName))
+	if err != nil {
+		return nil, err
+	}
+	exists, err := store.Exists(ctx, blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return &bytestream.QueryWriteStatusResponse{CommittedSize: blobDigest.SizeBytes, Complete: true}, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "no write of this resource is in progress")
 }
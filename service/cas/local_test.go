@@ -0,0 +1,70 @@
+package cas
+
+import (
+	"testing"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/proto/distcache/cas"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func writeTestBlob(t *testing.T, s blob.Store, data string) *remoteexecution.Digest {
+	digest := util.DataToContentDigest(util.SHA256, []byte(data))
+	w, err := s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return digest
+}
+
+func TestBatchReadBlobs(t *testing.T) {
+	store := blob.NewInMemory(0)
+	l := &local{
+		cfg:     Config{BatchMaxBytes: 25},
+		stores:  blob.NewPerInstance(func(string) (blob.Store, error) { return store, nil }),
+		uploads: newUploads(0),
+	}
+	first := writeTestBlob(t, store, "first blob")
+	second := writeTestBlob(t, store, "secnd blob")
+	third := writeTestBlob(t, store, "third blob")
+	missing := util.DataToContentDigest(util.SHA256, []byte("missing blob"))
+
+	for _, tcase := range []struct {
+		name    string
+		digests []*remoteexecution.Digest
+		codes   []codes.Code
+		data    []string
+	}{
+		{name: "empty"},
+		{
+			name:    "found_and_missing",
+			digests: []*remoteexecution.Digest{first, missing, second},
+			codes:   []codes.Code{codes.OK, codes.NotFound, codes.OK},
+			data:    []string{"first blob", "", "secnd blob"},
+		},
+		{
+			name:    "over_total_size",
+			digests: []*remoteexecution.Digest{first, second, third},
+			codes:   []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted},
+			data:    []string{"first blob", "secnd blob", ""},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			resp, err := l.BatchReadBlobs(context.Background(), &distcache_cas.BatchReadBlobsRequest{Digests: tcase.digests})
+			require.NoError(t, err, "failures of blobs shouldn't fail the batch")
+			require.Len(t, resp.Responses, len(tcase.digests), "each blob should get a response")
+			for i, blobResp := range resp.Responses {
+				assert.Equal(t, tcase.digests[i], blobResp.Digest, "responses should be in the order of the request")
+				assert.Equal(t, tcase.codes[i], status.FromProto(blobResp.Status).Code(), "unexpected status of blob %d", i)
+				assert.Equal(t, tcase.data[i], string(blobResp.Data), "unexpected data of blob %d", i)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package cas
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// uploads tracks partially written blobs by their upload resource name, so that interrupted ByteStream writes can be
+// resumed. Uploads that aren't resumed within the timeout are discarded.
+// Only resource names with an upload uuid identify a single upload, so writes to plain `blobs/{hash}/{size}` names
+// aren't tracked: concurrent writers of the same blob proceed independently, and can't resume.
+type uploads struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	pending map[string]*upload
+	stop    chan struct{}
+}
+
+type upload struct {
+	writer        blob.Writer
+	committedSize int64
+	inUse         bool
+	lastUsed      time.Time
+}
+
+// newUploads tracks uploads that can be resumed within the timeout, or none if it is 0.
+func newUploads(timeout time.Duration) *uploads {
+	u := &uploads{timeout: timeout, pending: make(map[string]*upload), stop: make(chan struct{})}
+	if timeout > 0 {
+		go u.expireLoop()
+	}
+	return u
+}
+
+func (u *uploads) expireLoop() {
+	ticker := time.NewTicker(u.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			u.expire(now)
+		case <-u.stop:
+			return
+		}
+	}
+}
+
+// close stops expiring suspended uploads.
+func (u *uploads) close() {
+	close(u.stop)
+}
+
+// resumable returns whether uploads of the resource can be suspended and resumed.
+func (u *uploads) resumable(resourceName string) bool {
+	return u.timeout > 0 && util.ResourcePathToUploadID(resourceName) != ""
+}
+
+// start registers a new upload, discarding a previous one of the same resource name that isn't in progress.
+func (u *uploads) start(resourceName string, writer blob.Writer) (*upload, error) {
+	up := &upload{writer: writer, inUse: true}
+	if !u.resumable(resourceName) {
+		return up, nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if previous, exists := u.pending[resourceName]; exists {
+		if previous.inUse {
+			return nil, status.Errorf(codes.Aborted, "another write of this resource is in progress")
+		}
+		previous.writer.Close()
+	}
+	u.pending[resourceName] = up
+	return up, nil
+}
+
+// resume takes over a suspended upload, as long as the offset is where it left off.
+func (u *uploads) resume(resourceName string, offset int64) (*upload, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, exists := u.pending[resourceName]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "no upload to resume, write from offset 0")
+	}
+	if up.inUse {
+		return nil, status.Errorf(codes.Aborted, "another write of this resource is in progress")
+	}
+	if up.committedSize != offset {
+		return nil, status.Errorf(codes.InvalidArgument, "write offset %d doesn't match committed size %d", offset, up.committedSize)
+	}
+	up.inUse = true
+	return up, nil
+}
+
+// commit records that n more bytes of the upload were written.
+func (u *uploads) commit(up *upload, n int) {
+	u.mu.Lock()
+	up.committedSize += int64(n)
+	u.mu.Unlock()
+}
+
+// suspend makes the upload available for resumption, or discards it if it can't be resumed.
+func (u *uploads) suspend(resourceName string, up *upload) {
+	u.mu.Lock()
+	tracked := u.pending[resourceName] == up
+	up.inUse = false
+	up.lastUsed = time.Now()
+	u.mu.Unlock()
+	if !tracked {
+		// Incomplete blobs fail verification and are discarded by the store.
+		up.writer.Close()
+	}
+}
+
+// finish forgets the upload, closing its writer.
+func (u *uploads) finish(resourceName string, up *upload) error {
+	u.mu.Lock()
+	if u.pending[resourceName] == up {
+		delete(u.pending, resourceName)
+	}
+	u.mu.Unlock()
+	return up.writer.Close()
+}
+
+// committedSize returns how much of the upload was written, and whether it is known at all.
+func (u *uploads) committedSize(resourceName string) (int64, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, exists := u.pending[resourceName]
+	if !exists {
+		return 0, false
+	}
+	return up.committedSize, true
+}
+
+// expire discards suspended uploads that weren't resumed in time.
+func (u *uploads) expire(now time.Time) {
+	u.mu.Lock()
+	var expired []*upload
+	for resourceName, up := range u.pending {
+		if !up.inUse && now.Sub(up.lastUsed) > u.timeout {
+			delete(u.pending, resourceName)
+			expired = append(expired, up)
+		}
+	}
+	u.mu.Unlock()
+	for _, up := range expired {
+		// Incomplete blobs fail verification and are discarded by the store.
+		up.writer.Close()
+	}
+}
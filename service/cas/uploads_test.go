@@ -0,0 +1,101 @@
+package cas
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeWriter struct {
+	closed bool
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error)     { return len(p), nil }
+func (w *fakeWriter) Close() error                    { w.closed = true; return nil }
+func (w *fakeWriter) Abort()                          { w.closed = true }
+func (w *fakeWriter) Digest() *remoteexecution.Digest { return nil }
+
+const testResourceName = "uploads/some-uuid/blobs/A0F4BBBB11114444/100"
+
+func TestUploads_ResumeOnlyAtCommittedSize(t *testing.T) {
+	u := newUploads(time.Minute)
+	defer u.close()
+	up, err := u.start(testResourceName, &fakeWriter{})
+	require.NoError(t, err)
+	u.commit(up, 40)
+
+	_, err = u.resume(testResourceName, 40)
+	assert.Equal(t, codes.Aborted, status.Code(err), "can't resume an upload in progress")
+
+	u.suspend(testResourceName, up)
+	committedSize, ok := u.committedSize(testResourceName)
+	assert.True(t, ok, "suspended upload should be known")
+	assert.EqualValues(t, 40, committedSize, "committed size should be reported")
+
+	_, err = u.resume(testResourceName, 30)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "can't resume at a different offset")
+	_, err = u.resume("uploads/other-uuid/blobs/A0F4BBBB11114444/100", 40)
+	assert.Equal(t, codes.NotFound, status.Code(err), "can't resume an unknown upload")
+	resumed, err := u.resume(testResourceName, 40)
+	require.NoError(t, err)
+	assert.Equal(t, up, resumed, "should resume the same upload")
+
+	require.NoError(t, u.finish(testResourceName, resumed))
+	_, ok = u.committedSize(testResourceName)
+	assert.False(t, ok, "finished upload should be forgotten")
+}
+
+func TestUploads_ExpireDiscardsSuspended(t *testing.T) {
+	u := newUploads(time.Minute)
+	defer u.close()
+	suspendedWriter, inUseWriter := &fakeWriter{}, &fakeWriter{}
+	suspended, err := u.start(testResourceName, suspendedWriter)
+	require.NoError(t, err)
+	u.suspend(testResourceName, suspended)
+	_, err = u.start("uploads/other-uuid/blobs/A0F4BBBB11114444/100", inUseWriter)
+	require.NoError(t, err)
+
+	u.expire(time.Now().Add(2 * time.Minute))
+	assert.True(t, suspendedWriter.closed, "suspended upload should be discarded")
+	assert.False(t, inUseWriter.closed, "upload in progress should be kept")
+	_, ok := u.committedSize(testResourceName)
+	assert.False(t, ok, "expired upload should be forgotten")
+}
+
+func TestUploads_WithoutUploadIDAreIndependent(t *testing.T) {
+	const resourceName = "blobs/A0F4BBBB11114444/100"
+	u := newUploads(time.Minute)
+	defer u.close()
+	firstWriter, secondWriter := &fakeWriter{}, &fakeWriter{}
+	first, err := u.start(resourceName, firstWriter)
+	require.NoError(t, err)
+	second, err := u.start(resourceName, secondWriter)
+	require.NoError(t, err, "concurrent writes of the same blob shouldn't conflict")
+	u.commit(first, 40)
+
+	_, ok := u.committedSize(resourceName)
+	assert.False(t, ok, "uploads without an upload uuid shouldn't be tracked")
+	u.suspend(resourceName, first)
+	assert.True(t, firstWriter.closed, "uploads without an upload uuid can't be resumed, so must be discarded")
+	_, err = u.resume(resourceName, 40)
+	assert.Equal(t, codes.NotFound, status.Code(err), "uploads without an upload uuid can't be resumed")
+	require.NoError(t, u.finish(resourceName, second))
+	assert.True(t, secondWriter.closed)
+}
+
+func TestUploads_NotResumableWithoutTimeout(t *testing.T) {
+	u := newUploads(0)
+	defer u.close()
+	writer := &fakeWriter{}
+	up, err := u.start(testResourceName, writer)
+	require.NoError(t, err)
+	u.suspend(testResourceName, up)
+	assert.True(t, writer.closed, "interrupted uploads must be discarded if they can't be resumed")
+	_, ok := u.committedSize(testResourceName)
+	assert.False(t, ok)
+}
@@ -0,0 +1,195 @@
+package cas
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// blobSizeHeader is the header metadata of ByteStream reads carrying the size of the blob, so that blobs read
+	// without their size can still be stored by a downstream cache reading through.
+	blobSizeHeader = "distcache-blob-size-bytes"
+)
+
+// findMissingUpstream narrows down the locally missing blobs to the ones that the upstream cache doesn't have either.
+// Upstream failures are not fatal: all locally missing blobs are reported as missing and bazel will upload them.
+func (l *local) findMissingUpstream(ctx context.Context, instanceName string, missing []*remoteexecution.Digest) []*remoteexecution.Digest {
+	upstreamResp, err := l.upstreamCas.FindMissingBlobs(ctx, &remoteexecution.FindMissingBlobsRequest{
+		InstanceName: instanceName,
+		BlobDigests:  missing,
+	})
+	if err != nil {
+		log.WithError(err).Warnf("upstream FindMissingBlobs failed, reporting local misses")
+		return missing
+	}
+	return upstreamResp.MissingBlobDigests
+}
+
+// readThrough streams the blob from the upstream cache down to bazel, writing it into the local store on the way.
+// Blobs read without their size (e.g. through the HTTP cache protocol) are only written if the upstream cache reports
+// it, otherwise they are only streamed.
+// Upstream failures before any data is sent are treated as misses, as an unavailable upstream shouldn't fail builds.
+func (l *local) readThrough(req *bytestream.ReadRequest, readStream bytestream.ByteStream_ReadServer, store blob.Store, blobDigest *remoteexecution.Digest) error {
+	if req.ReadOffset > blobDigest.SizeBytes {
+		return status.Errorf(codes.OutOfRange, "read offset larger than blob size")
+	}
+	ctx := readStream.Context()
+	// Always read the whole blob from upstream, as only whole blobs can be stored locally.
+	upstreamStream, err := l.upstreamByteStream.Read(ctx, &bytestream.ReadRequest{ResourceName: req.ResourceName})
+	if err != nil {
+		return upstreamMiss(err)
+	}
+	// Errors (including NotFound) arrive with the first message, make sure we don't create a local blob before it.
+	chunk, recvErr := upstreamStream.Recv()
+	if recvErr != nil && recvErr != io.EOF {
+		return upstreamMiss(recvErr)
+	}
+	if blobDigest.SizeBytes == 0 {
+		blobDigest = &remoteexecution.Digest{Hash: blobDigest.Hash, SizeBytes: upstreamBlobSize(upstreamStream)}
+	}
+	setBlobSizeHeader(readStream, blobDigest.SizeBytes)
+	var blobWriter blob.Writer
+	if blobDigest.SizeBytes > 0 {
+		blobWriter, err = store.Write(ctx, blobDigest)
+		if err != nil {
+			return err
+		}
+		defer blobWriter.Close()
+	}
+	var offset int64
+	for recvErr != io.EOF {
+		data := chunk.GetData()
+		if blobWriter != nil {
+			if _, err := blobWriter.Write(data); err != nil {
+				if statusErr, ok := status.FromError(err); ok {
+					return statusErr.Err()
+				}
+				return status.Errorf(codes.Internal, "cannot write upstream blob locally: %v", err)
+			}
+		}
+		if skip := req.ReadOffset - offset; skip < int64(len(data)) {
+			if skip > 0 {
+				data = data[skip:]
+			}
+			if err := readStream.Send(&bytestream.ReadResponse{Data: data}); err != nil {
+				return err
+			}
+		}
+		offset += int64(len(chunk.GetData()))
+		chunk, recvErr = upstreamStream.Recv()
+		if recvErr != nil && recvErr != io.EOF {
+			return recvErr
+		}
+	}
+	if blobWriter == nil {
+		return nil
+	}
+	if err := blobWriter.Close(); err != nil {
+		// Bazel already has the data and verifies it on its own, it just won't be cached locally.
+		log.WithError(err).Warnf("failed storing upstream blob %v locally", blobDigest.Hash)
+	}
+	return nil
+}
+
+// upstreamBlobSize returns the size of the blob reported by the upstream cache, or 0 if it didn't.
+func upstreamBlobSize(upstreamStream bytestream.ByteStream_ReadClient) int64 {
+	header, err := upstreamStream.Header()
+	if err != nil || len(header[blobSizeHeader]) != 1 {
+		return 0
+	}
+	size, err := strconv.ParseInt(header[blobSizeHeader][0], 10, 64)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+// setBlobSizeHeader tells downstream caches the size of the blob read, if it is known.
+func setBlobSizeHeader(readStream grpc.ServerStream, size int64) {
+	if size <= 0 {
+		return
+	}
+	if err := readStream.SetHeader(metadata.Pairs(blobSizeHeader, strconv.FormatInt(size, 10))); err != nil {
+		log.WithError(err).Warnf("failed setting blob size header")
+	}
+}
+
+// upstreamMiss logs a failed upstream read, unless the blob just doesn't exist, and reports it as a miss.
+func upstreamMiss(err error) error {
+	if status.Code(err) != codes.NotFound {
+		log.WithError(err).Warnf("upstream ByteStream Read failed, treating as a miss")
+	}
+	return status.Errorf(codes.NotFound, "blob doesnt exist")
+}
+
+// writeBack replicates a locally stored blob to the upstream cache, used by the writeback queue.
+func (l *local) writeBack(ctx context.Context, instanceName string, blobDigest *remoteexecution.Digest) error {
+	missingResp, err := l.upstreamCas.FindMissingBlobs(ctx, &remoteexecution.FindMissingBlobsRequest{
+		InstanceName: instanceName,
+		BlobDigests:  []*remoteexecution.Digest{blobDigest},
+	})
+	if err != nil {
+		return err
+	}
+	if len(missingResp.MissingBlobDigests) == 0 {
+		return nil
+	}
+	store, err := l.stores.Get(instanceName)
+	if err != nil {
+		return err
+	}
+	blobReader, err := store.Read(ctx, blobDigest)
+	if err != nil {
+		return err
+	}
+	defer blobReader.Close()
+	writeStream, err := l.upstreamByteStream.Write(ctx)
+	if err != nil {
+		return err
+	}
+	resourceName := util.ContentDigestToUploadResourcePath(instanceName, blobDigest)
+	chunkBuffer := make([]byte, l.cfg.ChunkSizeBytes)
+	var offset int64
+	for {
+		n, readErr := blobReader.Read(chunkBuffer)
+		if readErr != nil && readErr != io.EOF {
+			return status.Errorf(codes.Internal, "cannot read local blob: %v", readErr)
+		}
+		// The Reader fills the whole buffer, so a short read means we're at the end of the blob.
+		finished := readErr == io.EOF || n < len(chunkBuffer) || offset+int64(n) == blobDigest.SizeBytes
+		writeReq := &bytestream.WriteRequest{WriteOffset: offset, Data: chunkBuffer[:n], FinishWrite: finished}
+		if offset == 0 {
+			writeReq.ResourceName = resourceName
+		}
+		if err := writeStream.Send(writeReq); err != nil {
+			if err == io.EOF {
+				// The server closed the stream, the actual error is returned from CloseAndRecv.
+				break
+			}
+			return err
+		}
+		offset += int64(n)
+		if finished {
+			break
+		}
+	}
+	writeResp, err := writeStream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if writeResp.CommittedSize != blobDigest.SizeBytes {
+		return status.Errorf(codes.Internal, "upstream committed %d of %d bytes", writeResp.CommittedSize, blobDigest.SizeBytes)
+	}
+	return nil
+}
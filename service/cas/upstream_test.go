@@ -0,0 +1,143 @@
+package cas
+
+import (
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeUpstream is an upstream ByteStream serving blobs in chunks of 4 bytes, or failing every read with err.
+// With reportSize, it reports the sizes of blobs like a distcache.
+type fakeUpstream struct {
+	bytestream.ByteStreamClient
+	blobs      map[string][]byte
+	err        error
+	reportSize bool
+}
+
+func (f *fakeUpstream) Read(ctx context.Context, req *bytestream.ReadRequest, opts ...grpc.CallOption) (bytestream.ByteStream_ReadClient, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	blobDigest, err := util.ResourcePathToContentDigest(req.ResourceName)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := f.blobs[blobDigest.Hash]
+	if !ok {
+		// Like real streams, the error only arrives with the first message.
+		return &fakeReadClient{err: status.Errorf(codes.NotFound, "blob doesnt exist")}, nil
+	}
+	client := &fakeReadClient{data: data}
+	if f.reportSize {
+		client.header = metadata.Pairs(blobSizeHeader, strconv.Itoa(len(data)))
+	}
+	return client, nil
+}
+
+type fakeReadClient struct {
+	grpc.ClientStream
+	data   []byte
+	err    error
+	header metadata.MD
+}
+
+func (c *fakeReadClient) Header() (metadata.MD, error) {
+	return c.header, nil
+}
+
+func (c *fakeReadClient) Recv() (*bytestream.ReadResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if len(c.data) == 0 {
+		return nil, io.EOF
+	}
+	n := 4
+	if n > len(c.data) {
+		n = len(c.data)
+	}
+	chunk := c.data[:n]
+	c.data = c.data[n:]
+	return &bytestream.ReadResponse{Data: chunk}, nil
+}
+
+// fakeReadServer collects the data and header sent down to the client.
+type fakeReadServer struct {
+	grpc.ServerStream
+	data   []byte
+	header metadata.MD
+}
+
+func (s *fakeReadServer) SetHeader(header metadata.MD) error {
+	s.header = metadata.Join(s.header, header)
+	return nil
+}
+
+func (s *fakeReadServer) Send(resp *bytestream.ReadResponse) error {
+	s.data = append(s.data, resp.Data...)
+	return nil
+}
+
+func (s *fakeReadServer) Context() context.Context {
+	return context.Background()
+}
+
+func TestRead_ReadsThroughUpstream(t *testing.T) {
+	data := []byte("some blob content")
+	stored := util.DataToContentDigest(util.SHA256, data)
+	missing := util.DataToContentDigest(util.SHA256, []byte("missing blob"))
+
+	for _, tcase := range []struct {
+		name       string
+		digest     *remoteexecution.Digest
+		readOffset int64
+		upstream   *fakeUpstream
+		code       codes.Code
+		data       []byte
+		unstored   bool
+	}{
+		{name: "hit", digest: stored, upstream: &fakeUpstream{blobs: map[string][]byte{stored.Hash: data}}, data: data},
+		{name: "hit_with_offset", digest: stored, readOffset: 6, upstream: &fakeUpstream{blobs: map[string][]byte{stored.Hash: data}}, data: data[6:]},
+		{name: "hit_without_size", digest: &remoteexecution.Digest{Hash: stored.Hash}, upstream: &fakeUpstream{blobs: map[string][]byte{stored.Hash: data}, reportSize: true}, data: data},
+		{name: "hit_without_any_size", digest: &remoteexecution.Digest{Hash: stored.Hash}, upstream: &fakeUpstream{blobs: map[string][]byte{stored.Hash: data}}, data: data, unstored: true},
+		{name: "not_found", digest: missing, upstream: &fakeUpstream{blobs: map[string][]byte{stored.Hash: data}}, code: codes.NotFound},
+		{name: "unavailable", digest: stored, upstream: &fakeUpstream{err: status.Errorf(codes.Unavailable, "upstream is down")}, code: codes.NotFound},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			store := blob.NewInMemory(0)
+			l := &local{
+				cfg:                Config{ChunkSizeBytes: 1024},
+				stores:             blob.NewPerInstance(func(string) (blob.Store, error) { return store, nil }),
+				uploads:            newUploads(0),
+				upstreamByteStream: tcase.upstream,
+			}
+			readStream := &fakeReadServer{}
+			err := l.Read(&bytestream.ReadRequest{
+				ResourceName: util.ContentDigestToResourcePath("", tcase.digest),
+				ReadOffset:   tcase.readOffset,
+			}, readStream)
+			assert.Equal(t, tcase.code, status.Code(err), "unexpected error: %v", err)
+			assert.Equal(t, tcase.data, readStream.data, "unexpected data sent to the client")
+
+			exists, err := store.Exists(context.Background(), tcase.digest)
+			require.NoError(t, err)
+			assert.Equal(t, tcase.code == codes.OK && !tcase.unstored, exists, "only blobs found upstream with a known size should be stored locally, whole")
+			if exists {
+				assert.Equal(t, []string{strconv.Itoa(len(data))}, readStream.header[blobSizeHeader], "size should be reported downstream")
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package cas
+
+import (
+	remoteexecution_v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/proto/distcache/cas"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// localV2 serves the REAPI v2 ContentAddressableStorage from the same stores as the v1test one.
+// ByteStream resource names are the same in both versions, so the ByteStream service is shared.
+type localV2 struct {
+	l *local
+}
+
+func (l *local) V2() remoteexecution_v2.ContentAddressableStorageServer {
+	return &localV2{l: l}
+}
+
+func (v *localV2) FindMissingBlobs(ctx context.Context, req *remoteexecution_v2.FindMissingBlobsRequest) (*remoteexecution_v2.FindMissingBlobsResponse, error) {
+	resp, err := v.l.FindMissingBlobs(ctx, &remoteexecution.FindMissingBlobsRequest{
+		InstanceName: req.InstanceName,
+		BlobDigests:  util.DigestsFromV2(req.BlobDigests),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &remoteexecution_v2.FindMissingBlobsResponse{MissingBlobDigests: util.DigestsToV2(resp.MissingBlobDigests)}, nil
+}
+
+func (v *localV2) BatchUpdateBlobs(ctx context.Context, req *remoteexecution_v2.BatchUpdateBlobsRequest) (*remoteexecution_v2.BatchUpdateBlobsResponse, error) {
+	v1Req := &remoteexecution.BatchUpdateBlobsRequest{InstanceName: req.InstanceName}
+	for _, blobReq := range req.Requests {
+		v1Req.Requests = append(v1Req.Requests, &remoteexecution.UpdateBlobRequest{
+			ContentDigest: util.DigestFromV2(blobReq.Digest),
+			Data:          blobReq.Data,
+		})
+	}
+	v1Resp, err := v.l.BatchUpdateBlobs(ctx, v1Req)
+	if err != nil {
+		return nil, err
+	}
+	resp := &remoteexecution_v2.BatchUpdateBlobsResponse{}
+	for _, blobResp := range v1Resp.Responses {
+		resp.Responses = append(resp.Responses, &remoteexecution_v2.BatchUpdateBlobsResponse_Response{
+			Digest: util.DigestToV2(blobResp.BlobDigest),
+			Status: blobResp.Status,
+		})
+	}
+	return resp, nil
+}
+
+func (v *localV2) BatchReadBlobs(ctx context.Context, req *remoteexecution_v2.BatchReadBlobsRequest) (*remoteexecution_v2.BatchReadBlobsResponse, error) {
+	extResp, err := v.l.BatchReadBlobs(ctx, &distcache_cas.BatchReadBlobsRequest{
+		InstanceName: req.InstanceName,
+		Digests:      util.DigestsFromV2(req.Digests),
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &remoteexecution_v2.BatchReadBlobsResponse{}
+	for _, blobResp := range extResp.Responses {
+		resp.Responses = append(resp.Responses, &remoteexecution_v2.BatchReadBlobsResponse_Response{
+			Digest: util.DigestToV2(blobResp.Digest),
+			Data:   blobResp.Data,
+			Status: blobResp.Status,
+		})
+	}
+	return resp, nil
+}
+
+func (v *localV2) GetTree(*remoteexecution_v2.GetTreeRequest, remoteexecution_v2.ContentAddressableStorage_GetTreeServer) error {
+	return status.Errorf(codes.Unimplemented, "GetTree is unused by bazel.")
+}
@@ -0,0 +1,274 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	actionCachePrefix = "ac"
+	casPrefix         = "cas"
+	// maxActionResultBytes bounds the memory used for parsing uploaded action results, which are small.
+	maxActionResultBytes = 4 * 1024 * 1024
+	// uploadChunkBytes is the size of chunks of uploaded blobs passed to the ByteStream service.
+	uploadChunkBytes = 1024 * 1024
+)
+
+// errLengthRequired rejects blob uploads without a Content-Length, as the size is part of the digest.
+var errLengthRequired = status.Errorf(codes.FailedPrecondition, "Content-Length must be set for blob uploads")
+
+var (
+	requestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "distcache",
+			Subsystem: "httpcache",
+			Name:      "requests_total",
+			Help:      "Number of HTTP cache protocol requests, by method, kind (ac or cas) and HTTP status code.",
+		}, []string{"method", "kind", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsCounter)
+}
+
+// New builds the handler of bazel's HTTP remote cache protocol (`GET/PUT /ac/<hash>` and `/cas/<hash>`, optionally
+// prefixed with an instance name).
+// Action results go through the actionCache service and blobs through the ByteStream service, so they behave like
+// ones of gRPC clients, including reading through and writing back to the upstream cache.
+func New(byteStream bytestream.ByteStreamServer, actionCache remoteexecution.ActionCacheServer) http.Handler {
+	return &server{byteStream: byteStream, actionCache: actionCache}
+}
+
+type server struct {
+	byteStream  bytestream.ByteStreamServer
+	actionCache remoteexecution.ActionCacheServer
+}
+
+func (s *server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	recorder := &statusRecorder{ResponseWriter: resp, code: http.StatusOK}
+	instanceName, kind, hash, ok := parsePath(req.URL.Path)
+	if !ok {
+		http.Error(recorder, "path must be [<instance_name>/]{ac,cas}/<hash>", http.StatusNotFound)
+		requestsCounter.WithLabelValues(req.Method, "unknown", strconv.Itoa(recorder.code)).Inc()
+		return
+	}
+	req = req.WithContext(withPeer(req))
+	var err error
+	switch {
+	case kind == actionCachePrefix && req.Method == http.MethodGet:
+		err = s.getActionResult(recorder, req, instanceName, hash)
+	case kind == actionCachePrefix && req.Method == http.MethodPut:
+		err = s.updateActionResult(recorder, req, instanceName, hash)
+	case kind == casPrefix && req.Method == http.MethodGet:
+		err = s.readBlob(recorder, req, instanceName, hash)
+	case kind == casPrefix && req.Method == http.MethodPut:
+		err = s.writeBlob(recorder, req, instanceName, hash)
+	default:
+		err = status.Errorf(codes.Unimplemented, "method %v is not supported", req.Method)
+	}
+	if err != nil {
+		if recorder.wroteHeader {
+			// The body is partially sent, all that can be done is cutting it short.
+			log.WithError(err).Warnf("httpcache failed serving %v %v", req.Method, req.URL.Path)
+		} else {
+			http.Error(recorder, status.Convert(err).Message(), httpStatusCode(err))
+		}
+	}
+	requestsCounter.WithLabelValues(req.Method, kind, strconv.Itoa(recorder.code)).Inc()
+}
+
+func (s *server) getActionResult(resp http.ResponseWriter, req *http.Request, instanceName string, hash string) error {
+	actionResult, err := s.actionCache.GetActionResult(req.Context(), &remoteexecution.GetActionResultRequest{
+		InstanceName: instanceName,
+		ActionDigest: &remoteexecution.Digest{Hash: hash},
+	})
+	if err != nil {
+		return err
+	}
+	// Action results of the v1test and v2 APIs are wire compatible, so either can be parsed by clients.
+	data, err := proto.Marshal(actionResult)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot marshal action result: %v", err)
+	}
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, err = resp.Write(data)
+	return err
+}
+
+func (s *server) updateActionResult(resp http.ResponseWriter, req *http.Request, instanceName string, hash string) error {
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, maxActionResultBytes+1))
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed reading request body: %v", err)
+	}
+	if len(data) > maxActionResultBytes {
+		return status.Errorf(codes.InvalidArgument, "action result larger than %d bytes", maxActionResultBytes)
+	}
+	actionResult := &remoteexecution.ActionResult{}
+	if err := proto.Unmarshal(data, actionResult); err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot parse action result: %v", err)
+	}
+	_, err = s.actionCache.UpdateActionResult(req.Context(), &remoteexecution.UpdateActionResultRequest{
+		InstanceName: instanceName,
+		ActionDigest: &remoteexecution.Digest{Hash: hash},
+		ActionResult: actionResult,
+	})
+	return err
+}
+
+func (s *server) readBlob(resp http.ResponseWriter, req *http.Request, instanceName string, hash string) error {
+	// The protocol doesn't carry the size of blobs, a size of 0 makes the stores report it when found.
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	return s.byteStream.Read(
+		&bytestream.ReadRequest{ResourceName: util.ContentDigestToResourcePath(instanceName, &remoteexecution.Digest{Hash: hash})},
+		&readServer{ctx: req.Context(), resp: resp})
+}
+
+func (s *server) writeBlob(resp http.ResponseWriter, req *http.Request, instanceName string, hash string) error {
+	if req.ContentLength < 0 {
+		return errLengthRequired
+	}
+	blobDigest := &remoteexecution.Digest{Hash: hash, SizeBytes: req.ContentLength}
+	// The service verifies the content, and discards the blob if it doesn't match the hash.
+	return s.byteStream.Write(&writeServer{
+		ctx:          req.Context(),
+		body:         io.LimitReader(req.Body, req.ContentLength),
+		resourceName: util.ContentDigestToUploadResourcePath(instanceName, blobDigest),
+		sizeBytes:    req.ContentLength,
+	})
+}
+
+// readServer passes the data of a ByteStream read to the HTTP response.
+type readServer struct {
+	// ServerStream is unset, only the methods used by the ByteStream service are implemented.
+	grpc.ServerStream
+	ctx  context.Context
+	resp http.ResponseWriter
+}
+
+func (r *readServer) Context() context.Context {
+	return r.ctx
+}
+
+// SetHeader ignores the header metadata, which HTTP clients don't need.
+func (r *readServer) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (r *readServer) Send(msg *bytestream.ReadResponse) error {
+	_, err := r.resp.Write(msg.Data)
+	return err
+}
+
+// writeServer passes the body of an HTTP request to a ByteStream write, in chunks.
+type writeServer struct {
+	// ServerStream is unset, only the methods used by the ByteStream service are implemented.
+	grpc.ServerStream
+	ctx          context.Context
+	body         io.Reader
+	resourceName string
+	sizeBytes    int64
+	offset       int64
+}
+
+func (w *writeServer) Context() context.Context {
+	return w.ctx
+}
+
+func (w *writeServer) Recv() (*bytestream.WriteRequest, error) {
+	chunk := make([]byte, uploadChunkBytes)
+	if remaining := w.sizeBytes - w.offset; remaining < int64(len(chunk)) {
+		chunk = chunk[:remaining]
+	}
+	n, err := io.ReadFull(w.body, chunk)
+	if err != nil && len(chunk) > 0 {
+		return nil, status.Errorf(codes.Unavailable, "failed reading request body: %v", err)
+	}
+	msg := &bytestream.WriteRequest{WriteOffset: w.offset, Data: chunk[:n], FinishWrite: w.offset+int64(n) == w.sizeBytes}
+	if w.offset == 0 {
+		msg.ResourceName = w.resourceName
+	}
+	w.offset += int64(n)
+	return msg, nil
+}
+
+func (w *writeServer) SendAndClose(*bytestream.WriteResponse) error {
+	return nil
+}
+
+// withPeer exposes the client's address like gRPC does, so that the action cache can apply the same policies.
+func withPeer(req *http.Request) context.Context {
+	addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+	if err != nil {
+		return req.Context()
+	}
+	return peer.NewContext(req.Context(), &peer.Peer{Addr: addr})
+}
+
+// parsePath splits `[<instance_name>/]{ac,cas}/<hash>` into its parts.
+func parsePath(urlPath string) (instanceName string, kind string, hash string, ok bool) {
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	if len(segments) < 2 {
+		return "", "", "", false
+	}
+	kind = segments[len(segments)-2]
+	if kind != actionCachePrefix && kind != casPrefix {
+		return "", "", "", false
+	}
+	return strings.Join(segments[:len(segments)-2], "/"), kind, segments[len(segments)-1], true
+}
+
+func httpStatusCode(err error) int {
+	if err == errLengthRequired {
+		return http.StatusLengthRequired
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unimplemented:
+		return http.StatusMethodNotAllowed
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// statusRecorder remembers the status code sent, for metrics and for knowing whether errors can still be reported.
+type statusRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(p)
+}
@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mwitkow/bazel-distcache/service/cas"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	helloHash = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+)
+
+type fakeActionCache struct {
+	results map[string]*remoteexecution.ActionResult
+}
+
+func (f *fakeActionCache) GetActionResult(ctx context.Context, req *remoteexecution.GetActionResultRequest) (*remoteexecution.ActionResult, error) {
+	if result, ok := f.results[req.InstanceName+"/"+req.ActionDigest.Hash]; ok {
+		return result, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "action doesnt exist")
+}
+
+func (f *fakeActionCache) UpdateActionResult(ctx context.Context, req *remoteexecution.UpdateActionResultRequest) (*remoteexecution.ActionResult, error) {
+	f.results[req.InstanceName+"/"+req.ActionDigest.Hash] = req.ActionResult
+	return req.ActionResult, nil
+}
+
+func doRequest(t *testing.T, handler http.Handler, method string, path string, body []byte) (int, []byte) {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	respBody, err := ioutil.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	return recorder.Code, respBody
+}
+
+func TestHttpCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpcache_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	blobBackend, err := blob.NewOnDiskBackend(dir, blob.OnDiskConfig{})
+	require.NoError(t, err)
+	casServer, err := cas.NewLocal(cas.Config{ChunkSizeBytes: 1024, BatchMaxBytes: 1024}, blob.NewPerInstanceForBackend(blobBackend), nil)
+	require.NoError(t, err)
+	handler := New(casServer, &fakeActionCache{results: make(map[string]*remoteexecution.ActionResult)})
+
+	code, _ := doRequest(t, handler, http.MethodGet, "/cas/"+helloHash, nil)
+	assert.Equal(t, http.StatusNotFound, code, "missing blob must be a 404")
+	code, _ = doRequest(t, handler, http.MethodPut, "/cas/"+helloHash, []byte("hello mars!"))
+	assert.Equal(t, http.StatusBadRequest, code, "blob not matching its hash must be rejected")
+	req := httptest.NewRequest(http.MethodPut, "/cas/"+helloHash, bytes.NewReader([]byte("hello world")))
+	req.ContentLength = -1
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusLengthRequired, recorder.Code, "blob uploads without a Content-Length must be rejected")
+	code, _ = doRequest(t, handler, http.MethodPut, "/cas/"+helloHash, []byte("hello world"))
+	assert.Equal(t, http.StatusOK, code)
+	code, body := doRequest(t, handler, http.MethodGet, "/cas/"+helloHash, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hello world", string(body))
+	code, _ = doRequest(t, handler, http.MethodGet, "/other/cas/"+helloHash, nil)
+	assert.Equal(t, http.StatusNotFound, code, "blobs of other instances must not be visible")
+
+	result := &remoteexecution.ActionResult{ExitCode: 0, StdoutDigest: &remoteexecution.Digest{Hash: helloHash, SizeBytes: 11}}
+	data, err := proto.Marshal(result)
+	require.NoError(t, err)
+	code, _ = doRequest(t, handler, http.MethodPut, "/other/ac/"+helloHash, data)
+	assert.Equal(t, http.StatusOK, code)
+	code, body = doRequest(t, handler, http.MethodGet, "/other/ac/"+helloHash, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, data, body)
+	code, _ = doRequest(t, handler, http.MethodGet, "/ac/"+helloHash, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = doRequest(t, handler, http.MethodGet, "/something/"+helloHash, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = doRequest(t, handler, http.MethodDelete, "/cas/"+helloHash, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
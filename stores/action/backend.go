@@ -0,0 +1,99 @@
+package action
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/mwitkow/bazel-distcache/common/sharedflags"
+)
+
+var (
+	storeURL = sharedflags.Set.String("actionstore_url", "",
+		"URL of the action store backend, e.g. file:///var/cache/actions?max_entries=1000000, bolt:///var/cache/actions.db, mem:// or s3://bucket/actions. If empty, the actionstore_* flags configure an ondisk one.")
+	diskPath           = sharedflags.Set.String("actionstore_ondisk_path", "/tmp/localcache-actionstore", "Path for the ondisk blob store directory.")
+	inMemoryMaxEntries = sharedflags.Set.Int("actionstore_inmemory_max_entries", defaultMemoryMaxEntries,
+		"Number of recently used ActionResults kept in memory in front of the ondisk action store.")
+	onDiskMaxEntries = sharedflags.Set.Int("actionstore_ondisk_max_entries", 0,
+		"Number of ActionResults on disk (per instance) above which the least recently used ones are evicted. Unbounded if 0.")
+	onDiskMaxAge = sharedflags.Set.Duration("actionstore_ondisk_max_age", 0,
+		"Time since last use after which ActionResults are evicted from disk. Unbounded if 0.")
+	onDiskEvictionInterval = sharedflags.Set.Duration("actionstore_ondisk_eviction_interval", defaultEvictionInterval,
+		"Interval between scans of the ondisk action store for entries to evict.")
+
+	backendOpeners = make(map[string]func(backendURL *url.URL) (Backend, error))
+)
+
+func init() {
+	RegisterBackend("mem", openInMemoryBackend)
+}
+
+// Backend is a kind of storage of ActionResults, holding a separate Store for each instance.
+type Backend interface {
+	// ForInstance constructs the Store of the instance. It is called once per instance, see PerInstance.
+	ForInstance(instanceName string) (Store, error)
+	// InstanceNames returns the names of instances that may have ActionResults stored in the backend, e.g. by
+	// previous runs.
+	InstanceNames() ([]string, error)
+}
+
+// RegisterBackend makes a backend available for store URLs with the scheme. It is meant to be called from init
+// functions, and panics if the scheme is already taken.
+func RegisterBackend(scheme string, open func(backendURL *url.URL) (Backend, error)) {
+	if _, exists := backendOpeners[scheme]; exists {
+		panic(fmt.Sprintf("action store backend %q registered twice", scheme))
+	}
+	backendOpeners[scheme] = open
+}
+
+// OpenBackend opens the backend selected by the scheme of the URL, configured by the rest of it.
+func OpenBackend(rawURL string) (Backend, error) {
+	backendURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid action store URL %q: %v", rawURL, err)
+	}
+	open, exists := backendOpeners[backendURL.Scheme]
+	if !exists {
+		var schemes []string
+		for scheme := range backendOpeners {
+			schemes = append(schemes, scheme)
+		}
+		sort.Strings(schemes)
+		return nil, fmt.Errorf("unknown action store backend %q in %q, known: %v", backendURL.Scheme, rawURL, schemes)
+	}
+	return open(backendURL)
+}
+
+// OpenBackendFromFlags opens the backend of actionstore_url, or an ondisk one configured by the actionstore_* flags
+// if it is not set.
+func OpenBackendFromFlags() (Backend, error) {
+	if *storeURL != "" {
+		return OpenBackend(*storeURL)
+	}
+	return NewOnDiskBackend(*diskPath, OnDiskConfig{
+		MemoryMaxEntries: *inMemoryMaxEntries,
+		MaxEntries:       *onDiskMaxEntries,
+		MaxAge:           *onDiskMaxAge,
+		EvictionInterval: *onDiskEvictionInterval,
+	}), nil
+}
+
+// inMemoryBackend keeps the ActionResults of each instance in memory, until restarted.
+type inMemoryBackend struct{}
+
+// openInMemoryBackend opens `mem://`.
+func openInMemoryBackend(backendURL *url.URL) (Backend, error) {
+	if backendURL.Host != "" || backendURL.Path != "" || backendURL.RawQuery != "" {
+		return nil, fmt.Errorf("action store URL %q takes no parameters, use mem://", backendURL.String())
+	}
+	return inMemoryBackend{}, nil
+}
+
+func (inMemoryBackend) ForInstance(instanceName string) (Store, error) {
+	return NewInMemory(), nil
+}
+
+func (inMemoryBackend) InstanceNames() ([]string, error) {
+	// Nothing survives restarts, PerInstance knows about the instances in use.
+	return nil, nil
+}
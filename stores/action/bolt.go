@@ -0,0 +1,216 @@
+package action
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// boltInstancePrefix starts the names of the buckets holding the ActionResults of each instance.
+	boltInstancePrefix = "instance/"
+	// boltWalkBatch is the number of ActionResults read per transaction in Walk, so that long walks don't keep a
+	// transaction open, which would block the database from growing.
+	boltWalkBatch = 1000
+	// boltOpenTimeout bounds waiting for the lock of a database used by another process, e.g. a running daemon.
+	boltOpenTimeout = 5 * time.Second
+)
+
+func init() {
+	RegisterBackend("bolt", openBoltBackend)
+}
+
+// BoltBackend stores ActionResults in a single embedded bbolt database file, with a bucket per instance.
+// Writes are transactional, and opening it doesn't scan the stored ActionResults.
+type BoltBackend struct {
+	db *bolt.DB
+}
+
+// NewBoltBackend opens, or creates, the database at dbPath. Only one process can have it open at a time.
+func NewBoltBackend(dbPath string) (*BoltBackend, error) {
+	if err := os.MkdirAll(path.Dir(dbPath), 0777); err != nil {
+		return nil, fmt.Errorf("bolt actionstore initialization error: %v", err)
+	}
+	db, err := bolt.Open(dbPath, 0666, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("bolt actionstore can't open %v: %v", dbPath, err)
+	}
+	return &BoltBackend{db: db}, nil
+}
+
+// openBoltBackend opens `bolt:///<database file>`.
+func openBoltBackend(backendURL *url.URL) (Backend, error) {
+	if backendURL.Host != "" || !path.IsAbs(backendURL.Path) || backendURL.RawQuery != "" {
+		return nil, fmt.Errorf("action store URL %q must be an absolute path, e.g. bolt:///var/cache/actions.db", backendURL.String())
+	}
+	return NewBoltBackend(backendURL.Path)
+}
+
+// Close closes the database, after which the stores of the backend can't be used.
+func (b *BoltBackend) Close() error {
+	return b.db.Close()
+}
+
+// ForInstance returns the storage of ActionResults of an instance, creating its bucket if needed.
+func (b *BoltBackend) ForInstance(instanceName string) (Store, error) {
+	// Instance names follow the same rules as for other backends.
+	if _, err := util.InstanceNameToPath(instanceName); err != nil {
+		return nil, err
+	}
+	bucket := []byte(boltInstancePrefix + instanceName)
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bolt actionstore can't create bucket of instance %q: %v", instanceName, err)
+	}
+	return &boltStore{db: b.db, bucket: bucket}, nil
+}
+
+// InstanceNames returns the names of instances with buckets in the database.
+func (b *BoltBackend) InstanceNames() ([]string, error) {
+	var names []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if strings.HasPrefix(string(name), boltInstancePrefix) {
+				names = append(names, strings.TrimPrefix(string(name), boltInstancePrefix))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "bolt actionstore can't list instances: %v", err)
+	}
+	return names, nil
+}
+
+type boltStore struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+func (s *boltStore) Get(actionDigest *remoteexecution.Digest) (*remoteexecution.ActionResult, error) {
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return nil, err
+	}
+	res := &remoteexecution.ActionResult{}
+	found := false
+	var parseErr error
+	err = s.db.View(func(tx *bolt.Tx) error {
+		content := tx.Bucket(s.bucket).Get([]byte(key))
+		if content == nil {
+			return nil
+		}
+		found = true
+		// The content is only valid during the transaction, Unmarshal copies what it needs.
+		parseErr = proto.Unmarshal(content, res)
+		return nil
+	})
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unavailable, "bolt actionstore can't read action %v: %v", key, err)
+	}
+	if parseErr != nil {
+		return nil, grpc.Errorf(codes.Internal, "action is unparsable %v: %v", key, parseErr)
+	}
+	if !found {
+		return nil, grpc.Errorf(codes.NotFound, "action doesnt exist")
+	}
+	return res, nil
+}
+
+func (s *boltStore) Store(actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error {
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return err
+	}
+	content, err := proto.Marshal(actionResult)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "cannot marshal action result: %v", err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(key), content)
+	})
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "bolt actionstore can't store action: %v", err)
+	}
+	return nil
+}
+
+func (s *boltStore) Delete(actionDigest *remoteexecution.Digest) error {
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "bolt actionstore can't delete action: %v", err)
+	}
+	return nil
+}
+
+// Walk visits the ActionResults in key order, in batches read in separate transactions, so walkFn may modify the
+// store.
+func (s *boltStore) Walk(walkFn func(actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error) error {
+	var after []byte
+	for {
+		keys, results, err := s.readBatch(after)
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			actionDigest, err := util.KeyToContentDigest(key, 0)
+			if err != nil {
+				return err
+			}
+			if err := walkFn(actionDigest, results[i]); err != nil {
+				return err
+			}
+		}
+		if len(keys) < boltWalkBatch {
+			return nil
+		}
+		after = []byte(keys[len(keys)-1])
+	}
+}
+
+// readBatch reads up to boltWalkBatch ActionResults with keys after the given one, from the start if nil.
+func (s *boltStore) readBatch(after []byte) ([]string, []*remoteexecution.ActionResult, error) {
+	var keys []string
+	var results []*remoteexecution.ActionResult
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(s.bucket).Cursor()
+		var k, v []byte
+		if after == nil {
+			k, v = cursor.First()
+		} else if k, v = cursor.Seek(after); k != nil && string(k) == string(after) {
+			k, v = cursor.Next()
+		}
+		for ; k != nil && len(keys) < boltWalkBatch; k, v = cursor.Next() {
+			res := &remoteexecution.ActionResult{}
+			if err := proto.Unmarshal(v, res); err != nil {
+				return fmt.Errorf("action is unparsable %v: %v", string(k), err)
+			}
+			keys = append(keys, string(k))
+			results = append(results, res)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, grpc.Errorf(codes.Internal, "bolt actionstore can't walk actions: %v", err)
+	}
+	return keys, results, nil
+}
@@ -0,0 +1,112 @@
+package action
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBolt_PersistsPerInstance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actionstore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "actions.db")
+
+	backend, err := NewBoltBackend(dbPath)
+	require.NoError(t, err)
+	s, err := backend.ForInstance("release")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.Store(testAction(i)))
+	}
+	deleted, _ := testAction(0)
+	require.NoError(t, s.Delete(deleted))
+	_, err = backend.ForInstance("")
+	require.NoError(t, err)
+	_, err = backend.ForInstance("../escape")
+	assert.Error(t, err, "invalid instance names should be rejected")
+	require.NoError(t, backend.Close())
+
+	reopened, err := NewBoltBackend(dbPath)
+	require.NoError(t, err)
+	defer reopened.Close()
+	names, err := reopened.InstanceNames()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "release"}, names)
+	s, err = reopened.ForInstance("release")
+	require.NoError(t, err)
+	_, err = s.Get(deleted)
+	assert.Equal(t, codes.NotFound, status.Code(err), "deleted action must be NotFound")
+	for i := 1; i < 3; i++ {
+		digest, expected := testAction(i)
+		actual, err := s.Get(digest)
+		require.NoError(t, err)
+		assert.Equal(t, expected.ExitCode, actual.ExitCode, "should read the action after reopening")
+	}
+	other, err := reopened.ForInstance("")
+	require.NoError(t, err)
+	digest, _ := testAction(1)
+	_, err = other.Get(digest)
+	assert.Equal(t, codes.NotFound, status.Code(err), "instances must not share actions")
+}
+
+func TestBolt_WalkInBatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actionstore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	backend, err := NewBoltBackend(path.Join(dir, "actions.db"))
+	require.NoError(t, err)
+	defer backend.Close()
+	// Syncing every transaction makes storing thousands of actions slow.
+	backend.db.NoSync = true
+	s, err := backend.ForInstance("")
+	require.NoError(t, err)
+
+	count := 2*boltWalkBatch + 10
+	for i := 0; i < count; i++ {
+		digest := util.DataToContentDigest(util.SHA256, []byte(strconv.Itoa(i)))
+		require.NoError(t, s.Store(digest, &remoteexecution.ActionResult{ExitCode: int32(i)}))
+	}
+	seen := make(map[string]bool)
+	require.NoError(t, s.Walk(func(digest *remoteexecution.Digest, _ *remoteexecution.ActionResult) error {
+		assert.False(t, seen[digest.Hash], "each action should be walked once")
+		seen[digest.Hash] = true
+		// Deleting while walking must not skip other actions.
+		return s.Delete(digest)
+	}))
+	assert.Len(t, seen, count, "walk should visit all actions")
+}
+
+func TestBolt_GetDistinguishesCorruptAndUnavailable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actionstore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	backend, err := NewBoltBackend(path.Join(dir, "actions.db"))
+	require.NoError(t, err)
+	s, err := backend.ForInstance("")
+	require.NoError(t, err)
+	digest, _ := testAction(0)
+	key, err := util.ContentDigestToKey(digest)
+	require.NoError(t, err)
+	bs := s.(*boltStore)
+	require.NoError(t, bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bs.bucket).Put([]byte(key), []byte("not a proto"))
+	}))
+	_, err = s.Get(digest)
+	assert.Equal(t, codes.Internal, status.Code(err), "corrupt action must be Internal")
+
+	require.NoError(t, backend.Close())
+	_, err = s.Get(digest)
+	assert.Equal(t, codes.Unavailable, status.Code(err), "failed read must be Unavailable")
+}
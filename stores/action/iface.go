@@ -15,4 +15,31 @@ type Store interface {
 
 	// Store returns an ActionResult by its digest.
 	Store(actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error
+
+	// Delete removes the ActionResult of the digest, if it exists.
+	Delete(actionDigest *remoteexecution.Digest) error
+
+	// Walk calls walkFn for every ActionResult stored, stopping at the first error. Sizes of the action digests are
+	// unknown and zero. Actions stored or deleted during the walk may or may not be visited.
+	Walk(walkFn func(actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error) error
+}
+
+// ReferencedBlobs returns the digests of the blobs the ActionResult points to: outputs, trees of output directories
+// and stdout/stderr.
+func ReferencedBlobs(actionResult *remoteexecution.ActionResult) []*remoteexecution.Digest {
+	var digests []*remoteexecution.Digest
+	for _, f := range actionResult.OutputFiles {
+		digests = append(digests, f.Digest)
+	}
+	for _, d := range actionResult.OutputDirectories {
+		digests = append(digests, d.TreeDigest)
+	}
+	digests = append(digests, actionResult.StdoutDigest, actionResult.StderrDigest)
+	var ret []*remoteexecution.Digest
+	for _, d := range digests {
+		if d != nil {
+			ret = append(ret, d)
+		}
+	}
+	return ret
 }
@@ -21,7 +21,10 @@ type inMemory struct {
 }
 
 func (s *inMemory) Get(actionDigest *remoteexecution.Digest) (*remoteexecution.ActionResult, error) {
-	key := util.ContentDigestToBase64(actionDigest)
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return nil, err
+	}
 	s.mu.RLock()
 	val, exists := s.values[key]
 	s.mu.RUnlock()
@@ -32,9 +35,42 @@ func (s *inMemory) Get(actionDigest *remoteexecution.Digest) (*remoteexecution.A
 }
 
 func (s *inMemory) Store(actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error {
-	key := util.ContentDigestToBase64(actionDigest)
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return err
+	}
 	s.mu.Lock()
 	s.values[key] = actionResult
 	s.mu.Unlock()
 	return nil
 }
+
+func (s *inMemory) Delete(actionDigest *remoteexecution.Digest) error {
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.values, key)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *inMemory) Walk(walkFn func(actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error) error {
+	s.mu.RLock()
+	values := make(map[string]*remoteexecution.ActionResult, len(s.values))
+	for key, val := range s.values {
+		values[key] = val
+	}
+	s.mu.RUnlock()
+	for key, val := range values {
+		actionDigest, err := util.KeyToContentDigest(key, 0)
+		if err != nil {
+			return err
+		}
+		if err := walkFn(actionDigest, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
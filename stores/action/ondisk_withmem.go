@@ -1,28 +1,152 @@
 package action
 
 import (
+	"container/list"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/mwitkow/bazel-distcache/common/sharedflags"
 	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
 	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultMemoryMaxEntries = 10000
+	defaultEvictionInterval = 10 * time.Minute
 )
 
 var (
-	diskPath = sharedflags.Set.String("actionstore_ondisk_path", "/tmp/localcache-actionstore", "Path for the ondisk blob store directory.")
+	evictionsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "distcache",
+			Subsystem: "actionstore_ondisk",
+			Name:      "evictions_total",
+			Help:      "Number of ActionResults evicted from disk.",
+		})
 )
 
-// NewOnDisk constructs *very* naive storage of Action that is stored in a directory from flags.
-// It is backed by on-disk proto messages.
-func NewOnDisk() (Store, error) {
-	s := &onDisk{values: make(map[string]*remoteexecution.ActionResult), basePath: *diskPath}
+func init() {
+	prometheus.MustRegister(evictionsCounter)
+	RegisterBackend("file", openOnDiskBackend)
+}
+
+// OnDiskConfig configures the ondisk stores of ActionResults.
+type OnDiskConfig struct {
+	// MemoryMaxEntries is the number of recently used ActionResults kept in memory in front of the disk.
+	MemoryMaxEntries int
+	// MaxEntries is the number of ActionResults (per instance) above which the least recently used ones are evicted
+	// from disk. Unbounded if 0.
+	MaxEntries int
+	// MaxAge is the time since last use after which ActionResults are evicted from disk. Unbounded if 0.
+	MaxAge time.Duration
+	// EvictionInterval is the interval between scans for ActionResults to evict, 10 minutes if 0.
+	EvictionInterval time.Duration
+}
+
+// OnDiskBackend stores ActionResults as on-disk proto messages in a directory, with the ActionResults of each
+// instance in its own subdirectory.
+type OnDiskBackend struct {
+	basePath string
+	config   OnDiskConfig
+}
+
+// NewOnDiskBackend constructs the backend storing ActionResults under basePath.
+func NewOnDiskBackend(basePath string, config OnDiskConfig) *OnDiskBackend {
+	return &OnDiskBackend{basePath: basePath, config: config}
+}
+
+// openOnDiskBackend opens
+// `file:///<base path>[?memory_entries=<n>&max_entries=<n>&max_age=<duration>&eviction_interval=<duration>]`.
+func openOnDiskBackend(backendURL *url.URL) (Backend, error) {
+	if backendURL.Host != "" || !path.IsAbs(backendURL.Path) {
+		return nil, fmt.Errorf("action store URL %q must have an absolute path, e.g. file:///var/cache/actions", backendURL.String())
+	}
+	if err := util.CheckURLQuery(backendURL, "memory_entries", "max_entries", "max_age", "eviction_interval"); err != nil {
+		return nil, err
+	}
+	config := OnDiskConfig{MemoryMaxEntries: defaultMemoryMaxEntries}
+	query := backendURL.Query()
+	for param, dest := range map[string]*int{"memory_entries": &config.MemoryMaxEntries, "max_entries": &config.MaxEntries} {
+		if value := query.Get(param); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return nil, fmt.Errorf("%v of action store URL %q must be a non-negative number", param, backendURL.String())
+			}
+			*dest = parsed
+		}
+	}
+	for param, dest := range map[string]*time.Duration{"max_age": &config.MaxAge, "eviction_interval": &config.EvictionInterval} {
+		if value := query.Get(param); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed < 0 {
+				return nil, fmt.Errorf("%v of action store URL %q must be a non-negative duration", param, backendURL.String())
+			}
+			*dest = parsed
+		}
+	}
+	return NewOnDiskBackend(backendURL.Path, config), nil
+}
+
+// ForInstance constructs the storage of ActionResults of an instance, creating its directory if needed.
+// ActionResults are loaded lazily, with a bounded cache of recently used ones in memory. If limits are set, least
+// recently used ActionResults are evicted from disk in the background. The limits apply to each instance separately.
+func (b *OnDiskBackend) ForInstance(instanceName string) (Store, error) {
+	instancePath, err := b.InstancePath(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(instancePath, 0777); err != nil {
+		return nil, fmt.Errorf("ondisk actionstore initialization error: %v", err)
+	}
+	s, err := newOnDisk(instancePath, b.config.MemoryMaxEntries, b.config.MaxEntries, b.config.MaxAge)
+	if err != nil {
+		return nil, err
+	}
+	if s.maxEntries > 0 || s.maxAge > 0 {
+		interval := b.config.EvictionInterval
+		if interval == 0 {
+			interval = defaultEvictionInterval
+		}
+		go s.evictLoop(interval)
+	}
+	return s, nil
+}
+
+// InstancePath returns the directory holding the ActionResults of the instance.
+func (b *OnDiskBackend) InstancePath(instanceName string) (string, error) {
+	instancePath, err := util.InstanceNameToPath(instanceName)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(b.basePath, instancePath), nil
+}
+
+// InstanceNames returns the names of instances that may have ActionResults stored in the directory.
+func (b *OnDiskBackend) InstanceNames() ([]string, error) {
+	return util.InstanceNamesInPath(b.basePath)
+}
+
+func newOnDisk(basePath string, memoryMaxEntries int, maxEntries int, maxAge time.Duration) (*onDisk, error) {
+	s := &onDisk{
+		basePath:         basePath,
+		memoryMaxEntries: memoryMaxEntries,
+		maxEntries:       maxEntries,
+		maxAge:           maxAge,
+		values:           make(map[string]*list.Element),
+		lru:              list.New(),
+	}
 	if err := s.init(); err != nil {
 		return nil, err
 	}
@@ -30,44 +154,101 @@ func NewOnDisk() (Store, error) {
 }
 
 type onDisk struct {
-	mu       sync.RWMutex
-	basePath string
+	mu               sync.Mutex
+	basePath         string
+	memoryMaxEntries int
+	maxEntries       int
+	maxAge           time.Duration
 
-	values map[string]*remoteexecution.ActionResult
+	values map[string]*list.Element
+	lru    *list.List // of *actionEntry, most recently used at the front
+	// generation is incremented by every change of the ActionResults on disk, so that ones read from disk without
+	// holding the lock are only cached if they can't be stale.
+	generation uint64
 }
 
+type actionEntry struct {
+	key    string
+	action *remoteexecution.ActionResult
+}
+
+// init only cleans up after a crash. ActionResults are read from disk when they're first needed.
 func (s *onDisk) init() error {
 	files, err := ioutil.ReadDir(s.basePath)
 	if err != nil {
 		return fmt.Errorf("ondisk actionstore initialization error: %v", err)
 	}
-	s.mu.Lock()
 	for _, f := range files {
-		action, err := s.readActionFromDisk(f.Name())
-		if err != nil {
-			return err
+		if util.IsStagingFile(f.Name()) {
+			if err := os.Remove(path.Join(s.basePath, f.Name())); err != nil {
+				return fmt.Errorf("ondisk actionstore can't remove abandoned staging file: %v", err)
+			}
 		}
-		s.values[f.Name()] = action
 	}
-	s.mu.Unlock()
 	return nil
 }
 
 func (s *onDisk) Get(actionDigest *remoteexecution.Digest) (*remoteexecution.ActionResult, error) {
-	key := util.ContentDigestToBase64(actionDigest)
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	val, exists := s.values[key]
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	element, exists := s.values[key]
+	var cached *remoteexecution.ActionResult
+	if exists {
+		s.lru.MoveToFront(element)
+		cached = element.Value.(*actionEntry).action
+	}
+	generation := s.generation
+	s.mu.Unlock()
 	if exists {
-		return val, nil
+		s.touch(key)
+		return cached, nil
 	}
 	ret, err := s.readActionFromDisk(key)
 	if err != nil {
 		return nil, err
 	}
+	s.touch(key)
+	s.mu.Lock()
+	// A concurrent Store, Delete or eviction may have changed the action since it was read.
+	if _, exists := s.values[key]; !exists && s.generation == generation {
+		s.cacheLocked(key, ret)
+	}
+	s.mu.Unlock()
 	return ret, nil
 }
 
+// touch marks the file of the action as recently used, for the purpose of ondisk eviction.
+func (s *onDisk) touch(key string) {
+	if s.maxEntries == 0 && s.maxAge == 0 {
+		return
+	}
+	now := time.Now()
+	os.Chtimes(path.Join(s.basePath, key), now, now)
+}
+
+// cacheLocked puts the action at the front of the in memory cache, dropping the least recently used if it is full.
+func (s *onDisk) cacheLocked(key string, actionResult *remoteexecution.ActionResult) {
+	if element, exists := s.values[key]; exists {
+		element.Value.(*actionEntry).action = actionResult
+		s.lru.MoveToFront(element)
+		return
+	}
+	s.values[key] = s.lru.PushFront(&actionEntry{key: key, action: actionResult})
+	for s.lru.Len() > s.memoryMaxEntries {
+		s.forgetLocked(s.lru.Back().Value.(*actionEntry).key)
+	}
+}
+
+func (s *onDisk) forgetLocked(key string) {
+	if element, exists := s.values[key]; exists {
+		s.lru.Remove(element)
+		delete(s.values, key)
+	}
+}
+
 func (s *onDisk) readActionFromDisk(key string) (*remoteexecution.ActionResult, error) {
 	content, err := ioutil.ReadFile(path.Join(s.basePath, key))
 	if os.IsNotExist(err) {
@@ -83,23 +264,126 @@ func (s *onDisk) readActionFromDisk(key string) (*remoteexecution.ActionResult,
 }
 
 func (s *onDisk) Store(actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error {
-	key := util.ContentDigestToBase64(actionDigest)
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.generation++
 	if err := s.storeActionToDisk(key, actionResult); err != nil {
 		return err
 	}
-	s.values[key] = actionResult
+	s.cacheLocked(key, actionResult)
+	return nil
+}
+
+func (s *onDisk) Delete(actionDigest *remoteexecution.Digest) error {
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	s.forgetLocked(key)
+	if err := os.Remove(path.Join(s.basePath, key)); err != nil && !os.IsNotExist(err) {
+		return grpc.Errorf(codes.Internal, "ondisk actionstore can't remove file %v: %v", key, err)
+	}
+	return nil
+}
+
+// Walk reads the actions from disk, without caching them in memory or marking them as used.
+func (s *onDisk) Walk(walkFn func(actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error) error {
+	files, err := ioutil.ReadDir(s.basePath)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "ondisk actionstore can't list files: %v", err)
+	}
+	for _, f := range files {
+		if f.IsDir() || util.IsStagingFile(f.Name()) {
+			continue
+		}
+		actionDigest, err := util.KeyToContentDigest(f.Name(), 0)
+		if err != nil {
+			log.Warnf("ondisk actionstore skipping unknown file %v", f.Name())
+			continue
+		}
+		actionResult, err := s.readActionFromDisk(f.Name())
+		if status.Code(err) == codes.NotFound {
+			// Evicted or deleted since listed.
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := walkFn(actionDigest, actionResult); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// storeActionToDisk writes the action to a staging file first, so that readers never see partially written actions.
 func (s *onDisk) storeActionToDisk(key string, actionResult *remoteexecution.ActionResult) error {
 	bytes, err := proto.Marshal(actionResult)
 	if err != nil {
 		return grpc.Errorf(codes.Internal, "action is unmarshable %v: %v", key, err)
 	}
-	if err := ioutil.WriteFile(path.Join(s.basePath, key), bytes, 0666); err != nil {
+	file, err := ioutil.TempFile(s.basePath, util.StagingPrefix+key+"-")
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "ondisk actionstore can't create file %v: %v", key, err)
+	}
+	_, err = file.Write(bytes)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path.Join(s.basePath, key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
 		return grpc.Errorf(codes.Internal, "ondisk actionstore can't write file %v: %v", key, err)
 	}
 	return nil
 }
+
+func (s *onDisk) evictLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.evict(time.Now()); err != nil {
+			log.WithError(err).Errorf("ondisk actionstore eviction failed")
+		}
+	}
+}
+
+// evict removes the ActionResults from disk that were last used before maxAge, and the least recently used ones
+// above maxEntries.
+func (s *onDisk) evict(now time.Time) error {
+	files, err := ioutil.ReadDir(s.basePath)
+	if err != nil {
+		return err
+	}
+	var actionFiles []os.FileInfo
+	for _, f := range files {
+		if !f.IsDir() && !util.IsStagingFile(f.Name()) {
+			actionFiles = append(actionFiles, f)
+		}
+	}
+	// Most recently used first.
+	sort.Slice(actionFiles, func(i, j int) bool { return actionFiles[i].ModTime().After(actionFiles[j].ModTime()) })
+	for i, f := range actionFiles {
+		tooOld := s.maxAge > 0 && now.Sub(f.ModTime()) > s.maxAge
+		tooMany := s.maxEntries > 0 && i >= s.maxEntries
+		if !tooOld && !tooMany {
+			continue
+		}
+		s.mu.Lock()
+		s.generation++
+		s.forgetLocked(f.Name())
+		err := os.Remove(path.Join(s.basePath, f.Name()))
+		s.mu.Unlock()
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		evictionsCounter.Inc()
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package action
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testAction(i int) (*remoteexecution.Digest, *remoteexecution.ActionResult) {
+	digest := util.DataToContentDigest(util.SHA256, []byte{byte(i)})
+	return digest, &remoteexecution.ActionResult{ExitCode: int32(i)}
+}
+
+func TestOnDisk_LoadsLazilyWithBoundedMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actionstore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := newOnDisk(dir, 2, 0, 0)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Store(testAction(i)))
+	}
+	assert.Len(t, s.values, 2, "only the most recent actions should be kept in memory")
+
+	restarted, err := newOnDisk(dir, 2, 0, 0)
+	require.NoError(t, err)
+	assert.Empty(t, restarted.values, "nothing should be loaded on startup")
+	for i := 0; i < 5; i++ {
+		digest, expected := testAction(i)
+		actual, err := restarted.Get(digest)
+		require.NoError(t, err)
+		assert.Equal(t, expected.ExitCode, actual.ExitCode, "should read the action from disk")
+	}
+	assert.Len(t, restarted.values, 2, "only the most recent actions should be kept in memory")
+}
+
+func TestOnDisk_EvictsByCountAndAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actionstore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := newOnDisk(dir, 10, 3, time.Hour)
+	require.NoError(t, err)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		digest, action := testAction(i)
+		require.NoError(t, s.Store(digest, action))
+		// Action 0 is the least recently used, action 4 the most recent.
+		usedAt := now.Add(time.Duration(i-5) * time.Minute)
+		if i == 3 {
+			usedAt = now.Add(-2 * time.Hour)
+		}
+		key, err := util.ContentDigestToKey(digest)
+		require.NoError(t, err)
+		require.NoError(t, os.Chtimes(path.Join(dir, key), usedAt, usedAt))
+	}
+	require.NoError(t, s.evict(now))
+
+	for i, expected := range []codes.Code{codes.NotFound, codes.OK, codes.OK, codes.NotFound, codes.OK} {
+		digest, _ := testAction(i)
+		_, err := s.Get(digest)
+		assert.Equal(t, expected, status.Code(err), "unexpected result for action %d", i)
+	}
+}
+
+func TestOnDisk_ConcurrentGetDoesNotCacheStaleActions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actionstore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// Marking actions as used on disk widens the window between Get reading an action and caching it.
+	s, err := newOnDisk(dir, 10, 0, time.Hour)
+	require.NoError(t, err)
+	digest, _ := testAction(0)
+	key, err := util.ContentDigestToKey(digest)
+	require.NoError(t, err)
+
+	for i := 0; i < 500; i++ {
+		require.NoError(t, s.Store(digest, &remoteexecution.ActionResult{ExitCode: 1}))
+		// Make Get read the action from disk.
+		s.mu.Lock()
+		s.forgetLocked(key)
+		s.mu.Unlock()
+		var wg sync.WaitGroup
+		wg.Add(5)
+		for j := 0; j < 4; j++ {
+			go func() {
+				defer wg.Done()
+				s.Get(digest)
+			}()
+		}
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				require.NoError(t, s.Delete(digest))
+			} else {
+				require.NoError(t, s.Store(digest, &remoteexecution.ActionResult{ExitCode: 2}))
+			}
+		}()
+		wg.Wait()
+		actual, err := s.Get(digest)
+		if i%2 == 0 {
+			assert.Equal(t, codes.NotFound, status.Code(err), "deleted action must not come back")
+		} else {
+			require.NoError(t, err)
+			assert.EqualValues(t, 2, actual.ExitCode, "stored action must not be replaced by a stale one")
+		}
+	}
+}
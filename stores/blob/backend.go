@@ -0,0 +1,70 @@
+package blob
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/mwitkow/bazel-distcache/common/sharedflags"
+)
+
+var (
+	storeURL = sharedflags.Set.String("blobstore_url", "",
+		"URL of the blob store backend, e.g. file:///var/cache/blobs?max=10GB, mem://?max=2GB or s3://bucket/blobs. If empty, the blobstore_ondisk_* flags configure an ondisk one.")
+	tierURLs = sharedflags.Set.StringSlice("blobstore_tier_urls", nil,
+		"URLs of blob store backends layered as tiers, fastest first, e.g. mem://?max=1GB&tier_max_blob=1MB,file:///var/cache/blobs,grpc://distcache:10201. Takes precedence over blobstore_url.")
+	diskPath = sharedflags.Set.String("blobstore_ondisk_path", "/tmp/localcache-blobstore", "Path for the ondisk blob store directory.")
+	maxBytes = sharedflags.Set.Int64("blobstore_ondisk_max_bytes", 0,
+		"Size of blobs stored on disk (by all instances together) above which the least recently used ones are evicted. Unbounded if 0.")
+	lowWaterRatio = sharedflags.Set.Float64("blobstore_ondisk_eviction_low_water_ratio", defaultLowWaterRatio,
+		"Fraction of blobstore_ondisk_max_bytes down to which blobs are evicted once the maximum is exceeded.")
+
+	backendOpeners = make(map[string]func(backendURL *url.URL) (Backend, error))
+)
+
+// Backend is a kind of storage of blobs, holding a separate Store for each instance.
+type Backend interface {
+	// ForInstance constructs the Store of the instance. It is called once per instance, see PerInstance.
+	ForInstance(instanceName string) (Store, error)
+	// InstanceNames returns the names of instances that may have blobs stored in the backend, e.g. by previous runs.
+	InstanceNames() ([]string, error)
+}
+
+// RegisterBackend makes a backend available for store URLs with the scheme. It is meant to be called from init
+// functions, and panics if the scheme is already taken.
+func RegisterBackend(scheme string, open func(backendURL *url.URL) (Backend, error)) {
+	if _, exists := backendOpeners[scheme]; exists {
+		panic(fmt.Sprintf("blob store backend %q registered twice", scheme))
+	}
+	backendOpeners[scheme] = open
+}
+
+// OpenBackend opens the backend selected by the scheme of the URL, configured by the rest of it.
+func OpenBackend(rawURL string) (Backend, error) {
+	backendURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blob store URL %q: %v", rawURL, err)
+	}
+	open, exists := backendOpeners[backendURL.Scheme]
+	if !exists {
+		var schemes []string
+		for scheme := range backendOpeners {
+			schemes = append(schemes, scheme)
+		}
+		sort.Strings(schemes)
+		return nil, fmt.Errorf("unknown blob store backend %q in %q, known: %v", backendURL.Scheme, rawURL, schemes)
+	}
+	return open(backendURL)
+}
+
+// OpenBackendFromFlags opens the tiers of blobstore_tier_urls, or the backend of blobstore_url, or an ondisk one
+// configured by the blobstore_ondisk_* flags if neither is set.
+func OpenBackendFromFlags() (Backend, error) {
+	if len(*tierURLs) > 0 {
+		return openTieredBackend(*tierURLs)
+	}
+	if *storeURL != "" {
+		return OpenBackend(*storeURL)
+	}
+	return NewOnDiskBackend(*diskPath, OnDiskConfig{MaxBytes: *maxBytes, LowWaterRatio: *lowWaterRatio})
+}
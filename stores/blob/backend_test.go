@@ -0,0 +1,54 @@
+package blob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenBackend(t *testing.T) {
+	for _, tcase := range []struct {
+		input  string
+		isErr  bool
+		output Backend
+	}{
+		{input: "file:///var/cache/blobs", output: &OnDiskBackend{basePath: "/var/cache/blobs"}},
+		{input: "file:///var/cache/blobs?max=2GB&low_water_ratio=0.5",
+			output: &OnDiskBackend{basePath: "/var/cache/blobs", config: OnDiskConfig{MaxBytes: 2 << 30, LowWaterRatio: 0.5}}},
+		{input: "file://relative/blobs", isErr: true},
+		{input: "file:///var/cache/blobs?max_bytes=2GB", isErr: true},
+		{input: "file:///var/cache/blobs?max=lots", isErr: true},
+		{input: "file:///var/cache/blobs?low_water_ratio=2", isErr: true},
+		{input: "mem://?max=2GB", output: &inMemoryBackend{maxBytes: 2 << 30}},
+		{input: "mem:///var/cache/blobs", isErr: true},
+		{input: "ftp://example.com/blobs", isErr: true},
+		{input: "/var/cache/blobs", isErr: true},
+	} {
+		t.Run(tcase.input, func(t *testing.T) {
+			out, err := OpenBackend(tcase.input)
+			if tcase.isErr {
+				assert.Error(t, err, "should return an error")
+			} else {
+				assert.NoError(t, err, "should not return an error")
+				assertBackendsEqual(t, tcase.output, out)
+			}
+		})
+	}
+}
+
+// assertBackendsEqual compares the configuration of backends, ignoring the state of the blobs they hold.
+func assertBackendsEqual(t *testing.T, expected Backend, actual Backend) {
+	switch expected := expected.(type) {
+	case *OnDiskBackend:
+		if assert.IsType(t, expected, actual) {
+			assert.Equal(t, expected.basePath, actual.(*OnDiskBackend).basePath, "base path should be equal")
+			assert.Equal(t, expected.config, actual.(*OnDiskBackend).config, "config should be equal")
+		}
+	case *inMemoryBackend:
+		if assert.IsType(t, expected, actual) {
+			assert.Equal(t, expected.maxBytes, actual.(*inMemoryBackend).maxBytes, "max bytes should be equal")
+		}
+	default:
+		assert.Equal(t, expected, actual, "should be equal")
+	}
+}
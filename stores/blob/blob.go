@@ -2,6 +2,7 @@ package blob
 
 import (
 	"io"
+	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
@@ -20,6 +21,11 @@ type Store interface {
 	Read(ctx context.Context, blobDigest *remoteexecution.Digest) (Reader, error)
 	// Write returns a BlogWriter for the digest.
 	Write(ctx context.Context, blobDigest *remoteexecution.Digest) (Writer, error)
+	// Delete removes the blob of the digest, if it exists. Readers that already opened it may still finish reading.
+	Delete(ctx context.Context, blobDigest *remoteexecution.Digest) error
+	// Walk calls walkFn for every blob stored, with the time it was last written, stopping at the first error.
+	// Blobs written or deleted during the walk may or may not be visited.
+	Walk(ctx context.Context, walkFn func(blobDigest *remoteexecution.Digest, lastWritten time.Time) error) error
 }
 
 type digestGetter interface {
@@ -37,8 +43,12 @@ type Reader interface {
 
 // Writer is an interface for writing blob contents into the store.
 // Each Write is guranteed to write the whole buffer, unless an error occurs.
-// Users *must* call Close() when they're done writing.
+// Users *must* call Close() when they're done writing. The blob is only visible to readers once Close succeeds, and
+// Close fails with an InvalidArgument error (discarding the blob) if the content written doesn't match the digest.
 type Writer interface {
 	io.WriteCloser
 	digestGetter
+	// Abort discards the blob instead of finishing the write, e.g. when the content can't be completed. Calling
+	// Close afterwards is a no-op.
+	Abort()
 }
@@ -0,0 +1,296 @@
+package blob
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	inMemoryUsedBytesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "distcache",
+			Subsystem: "blobstore_inmemory",
+			Name:      "used_bytes",
+			Help:      "Total size of blobs stored in memory.",
+		})
+	inMemoryEvictionsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "distcache",
+			Subsystem: "blobstore_inmemory",
+			Name:      "evictions_total",
+			Help:      "Number of blobs evicted from memory.",
+		})
+)
+
+func init() {
+	prometheus.MustRegister(inMemoryUsedBytesGauge, inMemoryEvictionsCounter)
+	RegisterBackend("mem", openInMemoryBackend)
+}
+
+// NewInMemory constructs storage of Blobs that is kept in memory only, e.g. for ephemeral CI containers and tests.
+// Once the blobs take more than maxBytes, the least recently used ones are evicted. Unbounded if 0.
+func NewInMemory(maxBytes int64) Store {
+	return newInMemory(newMemoryUsage(maxBytes))
+}
+
+// inMemoryBackend keeps the blobs of all instances in memory, within a budget shared by them.
+type inMemoryBackend struct {
+	maxBytes int64
+	usage    *memoryUsage
+
+	mu     sync.Mutex
+	stores map[string]*inMemory
+}
+
+// openInMemoryBackend opens `mem://[?max=<size>]`.
+func openInMemoryBackend(backendURL *url.URL) (Backend, error) {
+	if backendURL.Host != "" || backendURL.Path != "" {
+		return nil, fmt.Errorf("blob store URL %q must not have a path, e.g. mem://?max=2GB", backendURL.String())
+	}
+	if err := util.CheckURLQuery(backendURL, "max"); err != nil {
+		return nil, err
+	}
+	backend := &inMemoryBackend{stores: make(map[string]*inMemory)}
+	if max := backendURL.Query().Get("max"); max != "" {
+		bytes, err := util.ParseByteSize(max)
+		if err != nil {
+			return nil, err
+		}
+		backend.maxBytes = bytes
+	}
+	backend.usage = newMemoryUsage(backend.maxBytes)
+	return backend, nil
+}
+
+func (b *inMemoryBackend) ForInstance(instanceName string) (Store, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	store, ok := b.stores[instanceName]
+	if !ok {
+		store = newInMemory(b.usage)
+		b.stores[instanceName] = store
+	}
+	return store, nil
+}
+
+func (b *inMemoryBackend) InstanceNames() ([]string, error) {
+	// Nothing survives restarts, PerInstance knows about the instances in use.
+	return nil, nil
+}
+
+// memoryUsage accounts for the blobs of all instances of a backend. Once they take more than maxBytes, the least
+// recently used blobs of any instance are evicted.
+type memoryUsage struct {
+	mu        sync.Mutex
+	maxBytes  int64
+	usedBytes int64
+	lru       *list.List // of *memoryEntry, most recently used at the front
+}
+
+func newMemoryUsage(maxBytes int64) *memoryUsage {
+	return &memoryUsage{maxBytes: maxBytes, lru: list.New()}
+}
+
+func newInMemory(usage *memoryUsage) *inMemory {
+	return &inMemory{usage: usage, entries: make(map[string]*list.Element)}
+}
+
+type inMemory struct {
+	usage *memoryUsage
+	// entries are the elements of the blobs of this store in the LRU list of usage, guarded by its lock.
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	store       *inMemory
+	key         string
+	data        []byte
+	lastWritten time.Time
+}
+
+// get returns the blob, marking it as recently used. Its data must not be modified.
+func (s *inMemory) get(blobKey string) (*memoryEntry, bool) {
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	element, exists := s.entries[blobKey]
+	if !exists {
+		return nil, false
+	}
+	s.usage.lru.MoveToFront(element)
+	return element.Value.(*memoryEntry), true
+}
+
+// publish makes a fully written blob visible, evicting least recently used blobs of any instance to make room for it.
+func (s *inMemory) publish(blobKey string, data []byte) {
+	u := s.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s.removeLocked(blobKey)
+	s.entries[blobKey] = u.lru.PushFront(&memoryEntry{store: s, key: blobKey, data: data, lastWritten: time.Now()})
+	u.addUsedBytesLocked(int64(len(data)))
+	for u.maxBytes > 0 && u.usedBytes > u.maxBytes {
+		entry := u.lru.Back().Value.(*memoryEntry)
+		entry.store.removeLocked(entry.key)
+		inMemoryEvictionsCounter.Inc()
+	}
+}
+
+func (s *inMemory) removeLocked(blobKey string) {
+	if element, exists := s.entries[blobKey]; exists {
+		s.usage.lru.Remove(element)
+		delete(s.entries, blobKey)
+		s.usage.addUsedBytesLocked(-int64(len(element.Value.(*memoryEntry).data)))
+	}
+}
+
+func (u *memoryUsage) addUsedBytesLocked(delta int64) {
+	u.usedBytes += delta
+	// Other backends have their own usage, so the gauge is moved by deltas rather than set.
+	inMemoryUsedBytesGauge.Add(float64(delta))
+}
+
+func (s *inMemory) Exists(ctx context.Context, blobDigest *remoteexecution.Digest) (bool, error) {
+	key, err := util.ContentDigestToKey(blobDigest)
+	if err != nil {
+		return false, err
+	}
+	_, exists := s.get(key)
+	return exists, nil
+}
+
+func (s *inMemory) Read(ctx context.Context, blobDigest *remoteexecution.Digest) (Reader, error) {
+	key, err := util.ContentDigestToKey(blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	entry, exists := s.get(key)
+	if !exists {
+		return nil, grpc.Errorf(codes.NotFound, "blob for contentdigest doesn't exist")
+	}
+	// Data of published blobs is never modified, so readers can share it, even after the blob is evicted.
+	return &memoryReader{
+		Reader: bytes.NewReader(entry.data),
+		digest: &remoteexecution.Digest{Hash: blobDigest.Hash, SizeBytes: int64(len(entry.data))},
+	}, nil
+}
+
+func (s *inMemory) Write(ctx context.Context, blobDigest *remoteexecution.Digest) (Writer, error) {
+	key, err := util.ContentDigestToKey(blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	digestFunction, err := util.DigestFunctionForHash(blobDigest.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if s.usage.maxBytes > 0 && blobDigest.SizeBytes > s.usage.maxBytes {
+		return nil, grpc.Errorf(codes.ResourceExhausted, "blob of %d bytes is larger than the inmemory blobstore", blobDigest.SizeBytes)
+	}
+	return &memoryWriter{
+		digest:   blobDigest,
+		store:    s,
+		key:      key,
+		verifier: util.NewContentVerifier(digestFunction),
+	}, nil
+}
+
+func (s *inMemory) Delete(ctx context.Context, blobDigest *remoteexecution.Digest) error {
+	key, err := util.ContentDigestToKey(blobDigest)
+	if err != nil {
+		return err
+	}
+	s.usage.mu.Lock()
+	s.removeLocked(key)
+	s.usage.mu.Unlock()
+	return nil
+}
+
+func (s *inMemory) Walk(ctx context.Context, walkFn func(blobDigest *remoteexecution.Digest, lastWritten time.Time) error) error {
+	s.usage.mu.Lock()
+	entries := make([]*memoryEntry, 0, len(s.entries))
+	for _, element := range s.entries {
+		entries = append(entries, element.Value.(*memoryEntry))
+	}
+	s.usage.mu.Unlock()
+	for _, entry := range entries {
+		blobDigest, err := util.KeyToContentDigest(entry.key, int64(len(entry.data)))
+		if err != nil {
+			return err
+		}
+		if err := walkFn(blobDigest, entry.lastWritten); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memoryReader reads a blob from memory. bytes.Reader always fills the buffer unless fewer bytes remain.
+type memoryReader struct {
+	*bytes.Reader
+	digest *remoteexecution.Digest
+}
+
+func (r *memoryReader) Close() error {
+	return nil
+}
+
+func (r *memoryReader) Digest() *remoteexecution.Digest {
+	return r.digest
+}
+
+// memoryWriter buffers the content of a blob, verifying it against the digest, and only makes the blob visible to
+// readers when it is closed with matching content.
+type memoryWriter struct {
+	buf      bytes.Buffer
+	digest   *remoteexecution.Digest
+	store    *inMemory
+	key      string
+	verifier *util.ContentVerifier
+	closed   bool
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, grpc.Errorf(codes.FailedPrecondition, "inmemory blobstore writer is closed")
+	}
+	// Bounds the memory used by clients sending more than they announced.
+	if int64(w.buf.Len()+len(p)) > w.digest.SizeBytes {
+		return 0, grpc.Errorf(codes.InvalidArgument, "blob is larger than digest size %d", w.digest.SizeBytes)
+	}
+	w.buf.Write(p)
+	w.verifier.Write(p)
+	return len(p), nil
+}
+
+// Close finishes the write. Calling it more than once is a no-op.
+func (w *memoryWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.verifier.Verify(w.digest); err != nil {
+		return err
+	}
+	w.store.publish(w.key, w.buf.Bytes())
+	return nil
+}
+
+func (w *memoryWriter) Abort() {
+	w.closed = true
+	w.buf.Reset()
+}
+
+func (w *memoryWriter) Digest() *remoteexecution.Digest {
+	return w.digest
+}
@@ -0,0 +1,120 @@
+package blob
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func writeTestBlob(t *testing.T, s Store, data string) *remoteexecution.Digest {
+	digest := util.DataToContentDigest(util.SHA256, []byte(data))
+	w, err := s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return digest
+}
+
+func TestInMemory_WriteAndFullBufferRead(t *testing.T) {
+	s := NewInMemory(0)
+	data := "some blob content"
+	digest := util.DataToContentDigest(util.SHA256, []byte(data))
+
+	w, err := s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(data))
+	require.NoError(t, err)
+	exists, _ := s.Exists(context.TODO(), digest)
+	assert.False(t, exists, "blob must not be visible before Close")
+	require.NoError(t, w.Close())
+
+	r, err := s.Read(context.TODO(), &remoteexecution.Digest{Hash: digest.Hash})
+	require.NoError(t, err)
+	defer r.Close()
+	assert.EqualValues(t, len(data), r.Digest().SizeBytes, "reader must expose the stored size")
+	buf := make([]byte, 10)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 10, n, "read must fill the buffer")
+	n, _ = r.Read(buf)
+	assert.Equal(t, data[10:], string(buf[:n]), "last read must return the remainder")
+}
+
+func TestInMemory_MismatchedWriteIsDiscarded(t *testing.T) {
+	s := NewInMemory(0)
+	digest := util.DataToContentDigest(util.SHA256, []byte("some blob content"))
+
+	w, err := s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("some blob CONTENT"))
+	require.NoError(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(w.Close()), "close should fail verification")
+	_, err = s.Read(context.TODO(), digest)
+	assert.Equal(t, codes.NotFound, status.Code(err), "mismatched blob must not be readable")
+
+	w, err = s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("some blob content and then some"))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "writing past the digest size should fail")
+}
+
+func TestInMemory_EvictsLeastRecentlyUsed(t *testing.T) {
+	s := NewInMemory(25)
+	first := writeTestBlob(t, s, "first blob")
+	second := writeTestBlob(t, s, "secnd blob")
+	// Make the first blob more recently used than the second one.
+	exists, _ := s.Exists(context.TODO(), first)
+	require.True(t, exists)
+	third := writeTestBlob(t, s, "third blob")
+
+	for i, d := range []*remoteexecution.Digest{first, second, third} {
+		exists, _ := s.Exists(context.TODO(), d)
+		assert.Equal(t, d != second, exists, "unexpected existence of blob %d", i)
+	}
+	_, err := s.Write(context.TODO(), &remoteexecution.Digest{Hash: first.Hash, SizeBytes: 26})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "blobs over the budget must be refused")
+}
+
+func TestInMemoryBackend_LimitAppliesToAllInstances(t *testing.T) {
+	backend, err := OpenBackend("mem://?max=25")
+	require.NoError(t, err)
+	first, err := backend.ForInstance("first")
+	require.NoError(t, err)
+	second, err := backend.ForInstance("second")
+	require.NoError(t, err)
+	oldest := writeTestBlob(t, first, "first blob")
+	writeTestBlob(t, second, "secnd blob")
+	writeTestBlob(t, second, "third blob")
+
+	exists, _ := first.Exists(context.TODO(), oldest)
+	assert.False(t, exists, "least recently used blob of any instance should be evicted")
+	assert.EqualValues(t, 20, backend.(*inMemoryBackend).usage.usedBytes, "used bytes of all instances should be within the budget")
+	again, err := backend.ForInstance("first")
+	require.NoError(t, err)
+	assert.Equal(t, first, again, "the store of an instance should be reused")
+}
+
+func TestInMemory_WalkAndDelete(t *testing.T) {
+	s := NewInMemory(0)
+	digest := writeTestBlob(t, s, "some blob content")
+
+	var walked []*remoteexecution.Digest
+	require.NoError(t, s.Walk(context.TODO(), func(d *remoteexecution.Digest, lastWritten time.Time) error {
+		walked = append(walked, d)
+		assert.WithinDuration(t, time.Now(), lastWritten, time.Minute, "last written should be recent")
+		return nil
+	}))
+	assert.Equal(t, []*remoteexecution.Digest{digest}, walked, "walk should visit the stored blob")
+
+	require.NoError(t, s.Delete(context.TODO(), digest))
+	exists, _ := s.Exists(context.TODO(), digest)
+	assert.False(t, exists, "deleted blob must not exist")
+}
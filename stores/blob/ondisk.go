@@ -1,14 +1,21 @@
 package blob
 
 import (
+	"container/list"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/mwitkow/bazel-distcache/common/sharedflags"
 	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
 	"google.golang.org/grpc"
@@ -17,26 +24,214 @@ import (
 
 const (
 	sizeNoExist = -1
+	// evictedPrefix marks files of removed blobs that are yet to be unlinked. It is a staging prefix too, so that the
+	// files are ignored by Walk and cleaned up on startup.
+	evictedPrefix = util.StagingPrefix + "evicted-"
+	// defaultLowWaterRatio is the fraction of the maximum size down to which blobs are evicted, unless configured.
+	defaultLowWaterRatio = 0.9
 )
 
 var (
-	diskPath = sharedflags.Set.String("blobstore_ondisk_path", "/tmp/localcache-blobstore", "Path for the ondisk blob store directory.")
+	usedBytesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "distcache",
+			Subsystem: "blobstore_ondisk",
+			Name:      "used_bytes",
+			Help:      "Total size of blobs stored on disk.",
+		})
+	evictionsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "distcache",
+			Subsystem: "blobstore_ondisk",
+			Name:      "evictions_total",
+			Help:      "Number of blobs evicted from disk.",
+		})
+	evictionDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "distcache",
+			Subsystem: "blobstore_ondisk",
+			Name:      "eviction_duration_seconds",
+			Help:      "Latency of bringing the blobs on disk back under the low water mark.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		})
 )
 
-// NewOnDisk constructs *very* naive storage of Blobs that is stored in a directory from flags.
-// No persistence, no expiration, just a lot of YOLO.
-func NewOnDisk() (Store, error) {
-	s := &onDisk{sizeCache: make(map[string]int64), basePath: *diskPath}
+func init() {
+	prometheus.MustRegister(usedBytesGauge, evictionsCounter, evictionDuration)
+	RegisterBackend("file", openOnDiskBackend)
+}
+
+// OnDiskConfig configures the ondisk stores of blobs.
+type OnDiskConfig struct {
+	// MaxBytes is the size of blobs stored (by all instances together) above which the least recently used ones are
+	// evicted in the background. Unbounded if 0.
+	MaxBytes int64
+	// LowWaterRatio is the fraction of MaxBytes down to which blobs are evicted once it is exceeded, 0.9 if 0.
+	LowWaterRatio float64
+}
+
+// OnDiskBackend is *very* naive storage of Blobs in a directory, with the blobs of each instance in its own
+// subdirectory.
+type OnDiskBackend struct {
+	basePath string
+	config   OnDiskConfig
+	usage    *diskUsage
+
+	mu     sync.Mutex
+	stores map[string]*onDisk
+}
+
+// NewOnDiskBackend constructs the backend storing blobs under basePath. The blobs of all instances already stored there
+// are accounted for, so that the size limit applies to them before the instances are used.
+func NewOnDiskBackend(basePath string, config OnDiskConfig) (*OnDiskBackend, error) {
+	b := &OnDiskBackend{basePath: basePath, config: config, usage: newDiskUsage(config), stores: make(map[string]*onDisk)}
+	instanceNames, err := b.InstanceNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, instanceName := range instanceNames {
+		instancePath, err := b.InstancePath(instanceName)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(instancePath); os.IsNotExist(err) {
+			// Only the directory of the default instance is listed even if it doesn't exist.
+			continue
+		}
+		store, err := newOnDisk(instancePath, b.usage)
+		if err != nil {
+			return nil, err
+		}
+		b.stores[instanceName] = store
+	}
+	return b, nil
+}
+
+// openOnDiskBackend opens `file:///<base path>[?max=<size>&low_water_ratio=<fraction>]`.
+func openOnDiskBackend(backendURL *url.URL) (Backend, error) {
+	if backendURL.Host != "" || !path.IsAbs(backendURL.Path) {
+		return nil, fmt.Errorf("blob store URL %q must have an absolute path, e.g. file:///var/cache/blobs", backendURL.String())
+	}
+	if err := util.CheckURLQuery(backendURL, "max", "low_water_ratio"); err != nil {
+		return nil, err
+	}
+	config := OnDiskConfig{}
+	query := backendURL.Query()
+	if max := query.Get("max"); max != "" {
+		bytes, err := util.ParseByteSize(max)
+		if err != nil {
+			return nil, err
+		}
+		config.MaxBytes = bytes
+	}
+	if ratio := query.Get("low_water_ratio"); ratio != "" {
+		parsed, err := strconv.ParseFloat(ratio, 64)
+		if err != nil || parsed <= 0 || parsed > 1 {
+			return nil, fmt.Errorf("low_water_ratio of blob store URL %q must be in (0, 1]", backendURL.String())
+		}
+		config.LowWaterRatio = parsed
+	}
+	return NewOnDiskBackend(backendURL.Path, config)
+}
+
+// ForInstance returns the storage of Blobs of an instance, creating its directory if needed.
+func (b *OnDiskBackend) ForInstance(instanceName string) (Store, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if store, ok := b.stores[instanceName]; ok {
+		return store, nil
+	}
+	instancePath, err := b.InstancePath(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(instancePath, 0777); err != nil {
+		return nil, fmt.Errorf("ondisk blobstore initialization error: %v", err)
+	}
+	store, err := newOnDisk(instancePath, b.usage)
+	if err != nil {
+		return nil, err
+	}
+	b.stores[instanceName] = store
+	return store, nil
+}
+
+// InstancePath returns the directory holding the blobs of the instance.
+func (b *OnDiskBackend) InstancePath(instanceName string) (string, error) {
+	instancePath, err := util.InstanceNameToPath(instanceName)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(b.basePath, instancePath), nil
+}
+
+// InstanceNames returns the names of instances that may have blobs stored in the directory.
+func (b *OnDiskBackend) InstanceNames() ([]string, error) {
+	return util.InstanceNamesInPath(b.basePath)
+}
+
+// diskUsage accounts for the blobs of all instances of a backend. If a maximum size is set, least recently used blobs
+// of any instance are evicted in the background once it is exceeded.
+//
+// Lookups only take the read lock, so they don't move blobs in the LRU list. Instead they record when the blob was
+// last used, and eviction moves blobs used since they were last queued back to the front rather than evicting them.
+type diskUsage struct {
+	clock         uint64 // incremented atomically on every use of a blob
+	mu            sync.RWMutex
+	maxBytes      int64
+	lowWaterBytes int64
+
+	usedBytes   int64
+	lru         *list.List // of *blobEntry, most recently used at the front
+	evictWakeup chan struct{}
+}
+
+func newDiskUsage(config OnDiskConfig) *diskUsage {
+	ratio := config.LowWaterRatio
+	if ratio == 0 {
+		ratio = defaultLowWaterRatio
+	}
+	u := &diskUsage{
+		maxBytes:      config.MaxBytes,
+		lowWaterBytes: int64(float64(config.MaxBytes) * ratio),
+		lru:           list.New(),
+		evictWakeup:   make(chan struct{}, 1),
+	}
+	if u.maxBytes > 0 {
+		go u.evictLoop()
+	}
+	return u
+}
+
+// newOnDisk constructs the store of blobs directly in basePath, accounted for in usage.
+func newOnDisk(basePath string, usage *diskUsage) (*onDisk, error) {
+	s := &onDisk{
+		basePath: basePath,
+		usage:    usage,
+		entries:  make(map[string]*list.Element),
+	}
 	if err := s.init(); err != nil {
 		return nil, err
 	}
+	if usage.maxBytes > 0 {
+		usage.wakeEvictor()
+	}
 	return s, nil
 }
 
 type onDisk struct {
-	mu        sync.RWMutex
-	basePath  string
-	sizeCache map[string]int64
+	basePath string
+	usage    *diskUsage
+	// entries are the elements of the blobs of this store in the LRU list of usage, guarded by its lock.
+	entries map[string]*list.Element
+}
+
+type blobEntry struct {
+	used   uint64 // clock of the last use, updated atomically under the read lock
+	queued uint64 // clock of the last move to the front of the LRU list
+	store  *onDisk
+	key    string
+	size   int64
 }
 
 func (s *onDisk) init() error {
@@ -44,35 +239,177 @@ func (s *onDisk) init() error {
 	if err != nil {
 		return fmt.Errorf("ondisk blobstore initialization error: %v", err)
 	}
+	// Modification time is the best approximation of recency we have after a restart.
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
 	for _, f := range files {
-		s.cacheSize(f.Name(), f.Size())
+		if f.IsDir() {
+			continue
+		}
+		if util.IsStagingFile(f.Name()) {
+			// Left behind by writes that were in progress during a crash.
+			if err := os.Remove(path.Join(s.basePath, f.Name())); err != nil {
+				return fmt.Errorf("ondisk blobstore can't remove abandoned staging file: %v", err)
+			}
+			continue
+		}
+		s.cacheSizeLocked(f.Name(), f.Size())
 	}
 	return nil
 }
 
+// getSize returns the size of the blob, marking it as recently used.
 func (s *onDisk) getSize(blobKey string) int64 {
-	s.mu.RLock()
-	value, exists := s.sizeCache[blobKey]
-	s.mu.RUnlock()
+	s.usage.mu.RLock()
+	defer s.usage.mu.RUnlock()
+	element, exists := s.entries[blobKey]
 	if !exists {
 		return sizeNoExist
 	}
-	return value
+	entry := element.Value.(*blobEntry)
+	atomic.StoreUint64(&entry.used, atomic.AddUint64(&s.usage.clock, 1))
+	return entry.size
 }
 
-func (s *onDisk) cacheSize(blobKey string, size int64) {
-	s.mu.Lock()
-	s.sizeCache[blobKey] = size
-	s.mu.Unlock()
+func (s *onDisk) cacheSizeLocked(blobKey string, size int64) {
+	u := s.usage
+	now := atomic.AddUint64(&u.clock, 1)
+	if element, exists := s.entries[blobKey]; exists {
+		entry := element.Value.(*blobEntry)
+		u.usedBytes += size - entry.size
+		entry.size = size
+		entry.queued = now
+		atomic.StoreUint64(&entry.used, now)
+		u.lru.MoveToFront(element)
+	} else {
+		s.entries[blobKey] = u.lru.PushFront(&blobEntry{used: now, queued: now, store: s, key: blobKey, size: size})
+		u.usedBytes += size
+	}
+	usedBytesGauge.Set(float64(u.usedBytes))
+}
+
+// publish moves a fully written staging file into place, making the blob visible.
+// It happens under the lock, so that eviction of a previous copy of the blob can't remove the new one.
+func (s *onDisk) publish(stagingFileName string, blobKey string, size int64) error {
+	u := s.usage
+	u.mu.Lock()
+	if err := os.Rename(stagingFileName, path.Join(s.basePath, blobKey)); err != nil {
+		u.mu.Unlock()
+		return err
+	}
+	s.cacheSizeLocked(blobKey, size)
+	overMax := u.maxBytes > 0 && u.usedBytes > u.maxBytes
+	u.mu.Unlock()
+	if overMax {
+		u.wakeEvictor()
+	}
+	return nil
+}
+
+func (u *diskUsage) wakeEvictor() {
+	select {
+	case u.evictWakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (u *diskUsage) evictLoop() {
+	for range u.evictWakeup {
+		u.evict()
+	}
+}
+
+// evict removes least recently used blobs until the used bytes are under the low water mark, if the maximum size is
+// exceeded.
+func (u *diskUsage) evict() {
+	u.mu.Lock()
+	overMax := u.usedBytes > u.maxBytes
+	u.mu.Unlock()
+	if !overMax {
+		return
+	}
+	start := time.Now()
+	for u.evictOne() {
+	}
+	evictionDuration.Observe(time.Since(start).Seconds())
+}
+
+func (u *diskUsage) evictOne() bool {
+	u.mu.Lock()
+	var entry *blobEntry
+	for {
+		element := u.lru.Back()
+		if element == nil || u.usedBytes <= u.lowWaterBytes {
+			u.mu.Unlock()
+			return false
+		}
+		entry = element.Value.(*blobEntry)
+		used := atomic.LoadUint64(&entry.used)
+		if used == entry.queued {
+			break
+		}
+		// Used since it was last queued, so it isn't the least recently used blob.
+		entry.queued = used
+		u.lru.MoveToFront(element)
+	}
+	evictionsCounter.Inc()
+	evictedFile, err := entry.store.discardLocked(entry.key)
+	u.mu.Unlock()
+	if err == nil {
+		err = removeDiscarded(evictedFile)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("ondisk blobstore can't remove evicted blob %v", entry.key)
+	}
+	return true
+}
+
+// discardLocked forgets the blob and moves its file out of the way, returning the name of the file to be removed by
+// removeDiscarded once the lock is released, or "" if there was none. Renaming is cheap compared to unlinking large
+// files, and a blob published under the same key later can't be removed by mistake.
+func (s *onDisk) discardLocked(blobKey string) (string, error) {
+	if element, exists := s.entries[blobKey]; exists {
+		s.usage.lru.Remove(element)
+		delete(s.entries, blobKey)
+		s.usage.usedBytes -= element.Value.(*blobEntry).size
+		usedBytesGauge.Set(float64(s.usage.usedBytes))
+	}
+	discardedFile := path.Join(s.basePath, evictedPrefix+blobKey)
+	if err := os.Rename(path.Join(s.basePath, blobKey), discardedFile); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return discardedFile, nil
+}
+
+// removeDiscarded removes the file of a blob returned by discardLocked.
+// Readers that already opened the file can still finish reading it.
+func removeDiscarded(discardedFile string) error {
+	if discardedFile == "" {
+		return nil
+	}
+	if err := os.Remove(discardedFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 func (s *onDisk) Exists(ctx context.Context, blobDigest *remoteexecution.Digest) (bool, error) {
-	key := util.ContentDigestToBase64(blobDigest)
+	key, err := util.ContentDigestToKey(blobDigest)
+	if err != nil {
+		return false, err
+	}
 	return s.getSize(key) != sizeNoExist, nil
 }
 
 func (s *onDisk) Read(ctx context.Context, blobDigest *remoteexecution.Digest) (Reader, error) {
-	key := util.ContentDigestToBase64(blobDigest)
+	key, err := util.ContentDigestToKey(blobDigest)
+	if err != nil {
+		return nil, err
+	}
 	fileName := path.Join(s.basePath, key)
 	size := s.getSize(key)
 	if size == sizeNoExist {
@@ -91,14 +428,63 @@ func (s *onDisk) Read(ctx context.Context, blobDigest *remoteexecution.Digest) (
 }
 
 func (s *onDisk) Write(ctx context.Context, blobDigest *remoteexecution.Digest) (Writer, error) {
-	key := util.ContentDigestToBase64(blobDigest)
-	fileName := path.Join(s.basePath, key)
-	file, err := os.Create(fileName)
+	key, err := util.ContentDigestToKey(blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	digestFunction, err := util.DigestFunctionForHash(blobDigest.Hash)
+	if err != nil {
+		return nil, err
+	}
+	// Writes go to a staging file, so that readers never see partially written blobs.
+	file, err := ioutil.TempFile(s.basePath, util.StagingPrefix+key+"-")
 	if err != nil {
 		return nil, grpc.Errorf(codes.Internal, "ondisk blobstore can't create file: %v", err)
 	}
-	s.cacheSize(key, blobDigest.SizeBytes)
-	return &blobFile{digest: blobDigest, file: file}, nil
+	return &blobFileWriter{
+		blobFile: blobFile{digest: blobDigest, file: file},
+		store:    s,
+		key:      key,
+		verifier: util.NewContentVerifier(digestFunction),
+	}, nil
+}
+
+func (s *onDisk) Delete(ctx context.Context, blobDigest *remoteexecution.Digest) error {
+	key, err := util.ContentDigestToKey(blobDigest)
+	if err != nil {
+		return err
+	}
+	s.usage.mu.Lock()
+	discardedFile, err := s.discardLocked(key)
+	s.usage.mu.Unlock()
+	if err == nil {
+		err = removeDiscarded(discardedFile)
+	}
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "ondisk blobstore can't remove file: %v", err)
+	}
+	return nil
+}
+
+func (s *onDisk) Walk(ctx context.Context, walkFn func(blobDigest *remoteexecution.Digest, lastWritten time.Time) error) error {
+	files, err := ioutil.ReadDir(s.basePath)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "ondisk blobstore can't list files: %v", err)
+	}
+	for _, f := range files {
+		if f.IsDir() || util.IsStagingFile(f.Name()) {
+			continue
+		}
+		blobDigest, err := util.KeyToContentDigest(f.Name(), f.Size())
+		if err != nil {
+			log.Warnf("ondisk blobstore skipping unknown file %v", f.Name())
+			continue
+		}
+		if err := walkFn(blobDigest, f.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // blobFile is a general implementation of both Writer and Reader, and which one it is
@@ -128,3 +514,54 @@ func (b *blobFile) Close() error {
 func (b *blobFile) Digest() *remoteexecution.Digest {
 	return b.digest
 }
+
+// blobFileWriter is a Writer that verifies the content written against the digest, and only makes the blob visible
+// to readers when it is closed with matching content, by renaming the staging file into place.
+// Staging files of blobs that don't match are removed on Close.
+type blobFileWriter struct {
+	blobFile
+	store    *onDisk
+	key      string
+	verifier *util.ContentVerifier
+	closed   bool
+}
+
+func (b *blobFileWriter) Write(p []byte) (n int, err error) {
+	n, err = b.file.Write(p)
+	b.verifier.Write(p[:n])
+	return n, err
+}
+
+// Abort removes the staging file.
+func (b *blobFileWriter) Abort() {
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
+
+// Close finishes the write. Calling it more than once is a no-op.
+func (b *blobFileWriter) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	err := b.file.Close()
+	if err != nil {
+		err = grpc.Errorf(codes.Internal, "ondisk blobstore can't close file: %v", err)
+	} else {
+		err = b.verifier.Verify(b.digest)
+	}
+	if err == nil {
+		if publishErr := b.store.publish(b.file.Name(), b.key, b.digest.SizeBytes); publishErr != nil {
+			err = grpc.Errorf(codes.Internal, "ondisk blobstore can't publish file: %v", publishErr)
+		}
+	}
+	if err != nil {
+		os.Remove(b.file.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestOnDisk(t *testing.T) (*onDisk, func()) {
+	dir, err := ioutil.TempDir("", "blobstore_test")
+	require.NoError(t, err)
+	s, err := newOnDisk(dir, newDiskUsage(OnDiskConfig{}))
+	require.NoError(t, err)
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestOnDisk_WriteIsVisibleOnlyAfterClose(t *testing.T) {
+	s, cleanup := newTestOnDisk(t)
+	defer cleanup()
+	data := []byte("some blob content")
+	digest := util.DataToContentDigest(util.SHA256, data)
+
+	w, err := s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	exists, _ := s.Exists(context.TODO(), digest)
+	assert.False(t, exists, "blob must not be visible before Close")
+
+	require.NoError(t, w.Close())
+	exists, _ = s.Exists(context.TODO(), digest)
+	assert.True(t, exists, "blob must be visible after Close")
+	r, err := s.Read(context.TODO(), digest)
+	require.NoError(t, err)
+	defer r.Close()
+	readData, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, readData, "read data should match written")
+}
+
+func TestOnDisk_MismatchedWriteIsDiscarded(t *testing.T) {
+	s, cleanup := newTestOnDisk(t)
+	defer cleanup()
+	digest := util.DataToContentDigest(util.SHA256, []byte("some blob content"))
+
+	w, err := s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("some blob CONTENT"))
+	require.NoError(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(w.Close()), "close should fail verification")
+
+	exists, _ := s.Exists(context.TODO(), digest)
+	assert.False(t, exists, "mismatched blob must not be visible")
+	_, err = s.Read(context.TODO(), &remoteexecution.Digest{Hash: digest.Hash, SizeBytes: digest.SizeBytes})
+	assert.Equal(t, codes.NotFound, status.Code(err), "mismatched blob must not be readable")
+	files, _ := ioutil.ReadDir(s.basePath)
+	assert.Empty(t, files, "mismatched blob must be removed from disk")
+}
+
+func TestOnDisk_InitRemovesAbandonedStagingFiles(t *testing.T) {
+	s, cleanup := newTestOnDisk(t)
+	defer cleanup()
+	data := []byte("some blob content")
+	digest := util.DataToContentDigest(util.SHA256, data)
+
+	w, err := s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write(data[:5])
+	require.NoError(t, err)
+	// Simulate a crash: the writer is never closed, and the store is reopened.
+
+	restarted, err := newOnDisk(s.basePath, newDiskUsage(OnDiskConfig{}))
+	require.NoError(t, err)
+	exists, _ := restarted.Exists(context.TODO(), digest)
+	assert.False(t, exists, "partially written blob must not be visible after restart")
+	files, _ := ioutil.ReadDir(s.basePath)
+	assert.Empty(t, files, "staging files must be removed on startup")
+}
+
+func TestOnDisk_EvictsLeastRecentlyUsed(t *testing.T) {
+	s, cleanup := newTestOnDisk(t)
+	defer cleanup()
+	s.usage.maxBytes = 25
+	s.usage.lowWaterBytes = 22
+
+	var digests []*remoteexecution.Digest
+	for _, data := range []string{"first blob", "secnd blob", "third blob"} {
+		digest := util.DataToContentDigest(util.SHA256, []byte(data))
+		w, err := s.Write(context.TODO(), digest)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		digests = append(digests, digest)
+		if len(digests) == 2 {
+			// Make the first blob more recently used than the second one.
+			exists, _ := s.Exists(context.TODO(), digests[0])
+			require.True(t, exists)
+		}
+	}
+	s.usage.evict()
+
+	for i, expected := range []bool{true, false, true} {
+		exists, _ := s.Exists(context.TODO(), digests[i])
+		assert.Equal(t, expected, exists, "unexpected existence of blob %d", i)
+	}
+	assert.EqualValues(t, 20, s.usage.usedBytes, "used bytes should be under the low water mark")
+	files, _ := ioutil.ReadDir(s.basePath)
+	assert.Len(t, files, 2, "evicted blob should be removed from disk")
+}
+
+func TestOnDiskBackend_LimitAppliesToAllInstances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobstore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	backend, err := NewOnDiskBackend(dir, OnDiskConfig{})
+	require.NoError(t, err)
+	backend.usage.maxBytes = 25
+	backend.usage.lowWaterBytes = 22
+
+	first, err := backend.ForInstance("first")
+	require.NoError(t, err)
+	second, err := backend.ForInstance("second")
+	require.NoError(t, err)
+	oldest := writeTestBlob(t, first, "first blob")
+	writeTestBlob(t, second, "secnd blob")
+	writeTestBlob(t, second, "third blob")
+	backend.usage.evict()
+
+	exists, _ := first.Exists(context.TODO(), oldest)
+	assert.False(t, exists, "least recently used blob of any instance should be evicted")
+	assert.EqualValues(t, 20, backend.usage.usedBytes, "used bytes of all instances should be under the low water mark")
+}
+
+func TestOnDiskBackend_LimitAppliesToUnusedInstances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobstore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	backend, err := NewOnDiskBackend(dir, OnDiskConfig{})
+	require.NoError(t, err)
+	unused, err := backend.ForInstance("unused")
+	require.NoError(t, err)
+	oldest := writeTestBlob(t, unused, "first blob")
+
+	restarted, err := NewOnDiskBackend(dir, OnDiskConfig{MaxBytes: 25, LowWaterRatio: 0.9})
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, restarted.usage.usedBytes, "blobs of instances not used since the restart must be accounted for")
+	used, err := restarted.ForInstance("used")
+	require.NoError(t, err)
+	writeTestBlob(t, used, "secnd blob")
+	writeTestBlob(t, used, "third blob")
+	restarted.usage.evict()
+
+	unused, err = restarted.ForInstance("unused")
+	require.NoError(t, err)
+	exists, _ := unused.Exists(context.TODO(), oldest)
+	assert.False(t, exists, "least recently used blob of an instance not used since the restart should be evicted")
+	assert.EqualValues(t, 20, restarted.usage.usedBytes, "used bytes of all instances should be under the low water mark")
+}
+
+func TestOnDisk_EvictionDoesntRemoveRepublishedBlob(t *testing.T) {
+	s, cleanup := newTestOnDisk(t)
+	defer cleanup()
+	digest := writeTestBlob(t, s, "first blob")
+	key, err := util.ContentDigestToKey(digest)
+	require.NoError(t, err)
+
+	s.usage.mu.Lock()
+	discarded, err := s.discardLocked(key)
+	s.usage.mu.Unlock()
+	require.NoError(t, err)
+	writeTestBlob(t, s, "first blob")
+	require.NoError(t, removeDiscarded(discarded))
+
+	exists, _ := s.Exists(context.TODO(), digest)
+	assert.True(t, exists, "blob written again after being discarded must be visible")
+	files, _ := ioutil.ReadDir(s.basePath)
+	assert.Len(t, files, 1, "only the republished blob should be on disk")
+}
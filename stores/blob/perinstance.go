@@ -0,0 +1,78 @@
+package blob
+
+import (
+	"sort"
+	"sync"
+)
+
+// Stores gives access to the Store of each instance name.
+type Stores interface {
+	// Get returns the Store of the instance.
+	Get(instanceName string) (Store, error)
+}
+
+// PerInstance holds a separate Store for each instance name, creating them on first use.
+type PerInstance struct {
+	mu      sync.Mutex
+	backend Backend
+	stores  map[string]Store
+}
+
+// NewPerInstance constructs a PerInstance that creates stores using the factory.
+func NewPerInstance(factory func(instanceName string) (Store, error)) *PerInstance {
+	return NewPerInstanceForBackend(factoryBackend(factory))
+}
+
+// NewPerInstanceForBackend constructs a PerInstance that creates the stores in the backend.
+func NewPerInstanceForBackend(backend Backend) *PerInstance {
+	return &PerInstance{backend: backend, stores: make(map[string]Store)}
+}
+
+// Get returns the Store of the instance.
+func (p *PerInstance) Get(instanceName string) (Store, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if store, exists := p.stores[instanceName]; exists {
+		return store, nil
+	}
+	store, err := p.backend.ForInstance(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	p.stores[instanceName] = store
+	return store, nil
+}
+
+// InstanceNames returns the sorted names of instances that have a Store already, or may have blobs in the backend.
+func (p *PerInstance) InstanceNames() ([]string, error) {
+	backendNames, err := p.backend.InstanceNames()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range backendNames {
+		seen[name] = true
+		names = append(names, name)
+	}
+	for name := range p.stores {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// factoryBackend is a Backend that knows nothing about instances it didn't create.
+type factoryBackend func(instanceName string) (Store, error)
+
+func (f factoryBackend) ForInstance(instanceName string) (Store, error) {
+	return f(instanceName)
+}
+
+func (f factoryBackend) InstanceNames() ([]string, error) {
+	return nil, nil
+}
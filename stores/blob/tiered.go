@@ -0,0 +1,307 @@
+package blob
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tierWritesParam  = "tier_writes"
+	tierMaxBlobParam = "tier_max_blob"
+)
+
+// Tier is one of the layers of a tiered store.
+type Tier struct {
+	Store Store
+	// SkipWrites keeps written blobs out of the tier, so that it is only filled with blobs read from slower tiers.
+	SkipWrites bool
+	// MaxBlobBytes keeps larger blobs out of the tier, e.g. for a tier in memory meant for small blobs. Unbounded if 0.
+	MaxBlobBytes int64
+}
+
+func (t *Tier) fits(blobDigest *remoteexecution.Digest) bool {
+	return t.MaxBlobBytes == 0 || blobDigest.SizeBytes <= t.MaxBlobBytes
+}
+
+// NewTiered constructs a Store layering the tiers, fastest first.
+// Blobs are looked up in the tiers in order, and blobs read from a slower tier are promoted into the faster tiers they
+// fit in while being read. Writes go to all tiers that take them.
+// A remote cache can be the slowest tier (see package remote), as an alternative to the read-through to an upstream
+// cache done by the CaS service (see `--upstream`) behind all tiers.
+func NewTiered(tiers []Tier) Store {
+	return &tiered{tiers: tiers}
+}
+
+type tiered struct {
+	tiers []Tier
+}
+
+func (t *tiered) Exists(ctx context.Context, blobDigest *remoteexecution.Digest) (bool, error) {
+	for _, tier := range t.tiers {
+		exists, err := tier.Store.Exists(ctx, blobDigest)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+func (t *tiered) Read(ctx context.Context, blobDigest *remoteexecution.Digest) (Reader, error) {
+	for i, tier := range t.tiers {
+		// Stores may fill in the size of the digest, so each gets its own copy.
+		reader, err := tier.Store.Read(ctx, &remoteexecution.Digest{Hash: blobDigest.Hash, SizeBytes: blobDigest.SizeBytes})
+		if status.Code(err) == codes.NotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return t.promoting(ctx, reader, t.tiers[:i]), nil
+	}
+	return nil, grpc.Errorf(codes.NotFound, "blob for contentdigest doesn't exist in any tier")
+}
+
+// promoting wraps the reader so that the blob is written into the faster tiers it fits in while it is read.
+func (t *tiered) promoting(ctx context.Context, reader Reader, fasterTiers []Tier) Reader {
+	blobDigest := reader.Digest()
+	var writers []Writer
+	for _, tier := range fasterTiers {
+		if !tier.fits(blobDigest) {
+			continue
+		}
+		writer, err := tier.Store.Write(ctx, &remoteexecution.Digest{Hash: blobDigest.Hash, SizeBytes: blobDigest.SizeBytes})
+		if err != nil {
+			log.WithError(err).Warnf("tiered blobstore can't promote blob %v", blobDigest.Hash)
+			continue
+		}
+		writers = append(writers, writer)
+	}
+	if len(writers) == 0 {
+		return reader
+	}
+	return &promotingReader{Reader: reader, writers: writers}
+}
+
+func (t *tiered) Write(ctx context.Context, blobDigest *remoteexecution.Digest) (Writer, error) {
+	w := &multiWriter{digest: blobDigest}
+	for _, tier := range t.tiers {
+		if tier.SkipWrites || !tier.fits(blobDigest) {
+			continue
+		}
+		writer, err := tier.Store.Write(ctx, &remoteexecution.Digest{Hash: blobDigest.Hash, SizeBytes: blobDigest.SizeBytes})
+		if err != nil {
+			w.Abort()
+			return nil, err
+		}
+		w.writers = append(w.writers, writer)
+	}
+	if len(w.writers) == 0 {
+		return nil, grpc.Errorf(codes.ResourceExhausted, "no tier takes writes of blobs of %d bytes", blobDigest.SizeBytes)
+	}
+	return w, nil
+}
+
+// Delete removes the blob from all tiers, so that it isn't promoted back.
+func (t *tiered) Delete(ctx context.Context, blobDigest *remoteexecution.Digest) error {
+	var firstErr error
+	for _, tier := range t.tiers {
+		if err := tier.Store.Delete(ctx, blobDigest); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Walk visits blobs stored in several tiers once, with the latest time any of them was written.
+func (t *tiered) Walk(ctx context.Context, walkFn func(blobDigest *remoteexecution.Digest, lastWritten time.Time) error) error {
+	type walkedBlob struct {
+		digest      *remoteexecution.Digest
+		lastWritten time.Time
+	}
+	blobs := make(map[string]*walkedBlob)
+	var keys []string
+	for _, tier := range t.tiers {
+		err := tier.Store.Walk(ctx, func(blobDigest *remoteexecution.Digest, lastWritten time.Time) error {
+			key, err := util.ContentDigestToKey(blobDigest)
+			if err != nil {
+				return err
+			}
+			if b, exists := blobs[key]; !exists {
+				blobs[key] = &walkedBlob{digest: blobDigest, lastWritten: lastWritten}
+				keys = append(keys, key)
+			} else if lastWritten.After(b.lastWritten) {
+				b.lastWritten = lastWritten
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		if err := walkFn(blobs[key].digest, blobs[key].lastWritten); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promotingReader copies the data read into writers of faster tiers. Writers that fail are dropped, and blobs that
+// aren't read to the end are discarded by the writers on Close, as their content doesn't match the digest.
+type promotingReader struct {
+	Reader
+	writers []Writer
+}
+
+func (r *promotingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		remaining := r.writers[:0]
+		for _, w := range r.writers {
+			if _, writeErr := w.Write(p[:n]); writeErr != nil {
+				log.WithError(writeErr).Warnf("tiered blobstore failed promoting blob %v", r.Digest().Hash)
+				w.Abort()
+				continue
+			}
+			remaining = append(remaining, w)
+		}
+		r.writers = remaining
+	}
+	return n, err
+}
+
+func (r *promotingReader) Close() error {
+	for _, w := range r.writers {
+		// Fails for partially read blobs, which is how they are discarded.
+		w.Close()
+	}
+	return r.Reader.Close()
+}
+
+// multiWriter writes the blob to several tiers. It fails if any of them fails, discarding the blob in all of them
+// so that it isn't stored in only some tiers, and clients retry.
+type multiWriter struct {
+	digest  *remoteexecution.Digest
+	writers []Writer
+	err     error
+}
+
+func (w *multiWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	for _, writer := range w.writers {
+		if _, err := writer.Write(p); err != nil {
+			w.err = err
+			w.Abort()
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *multiWriter) Abort() {
+	for _, writer := range w.writers {
+		writer.Abort()
+	}
+}
+
+func (w *multiWriter) Close() error {
+	if w.err != nil {
+		// The writers were aborted already.
+		return w.err
+	}
+	var firstErr error
+	for _, writer := range w.writers {
+		if err := writer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (w *multiWriter) Digest() *remoteexecution.Digest {
+	return w.digest
+}
+
+// tieredBackend opens the stores of each instance in all tier backends.
+type tieredBackend struct {
+	backends []Backend
+	tiers    []Tier // without stores, which are per instance
+}
+
+// openTieredBackend opens the backends of the URLs, fastest first. Besides the parameters of their backends, the
+// URLs take `tier_writes=false` and `tier_max_blob=<size>`, see Tier.
+func openTieredBackend(rawURLs []string) (Backend, error) {
+	b := &tieredBackend{}
+	for _, rawURL := range rawURLs {
+		tierURL, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blob store URL %q: %v", rawURL, err)
+		}
+		query := tierURL.Query()
+		tier := Tier{}
+		if writes := query.Get(tierWritesParam); writes != "" {
+			parsed, err := strconv.ParseBool(writes)
+			if err != nil {
+				return nil, fmt.Errorf("%v of blob store URL %q must be true or false", tierWritesParam, rawURL)
+			}
+			tier.SkipWrites = !parsed
+		}
+		if max := query.Get(tierMaxBlobParam); max != "" {
+			if tier.MaxBlobBytes, err = util.ParseByteSize(max); err != nil {
+				return nil, err
+			}
+		}
+		query.Del(tierWritesParam)
+		query.Del(tierMaxBlobParam)
+		tierURL.RawQuery = query.Encode()
+		backend, err := OpenBackend(tierURL.String())
+		if err != nil {
+			return nil, err
+		}
+		b.backends = append(b.backends, backend)
+		b.tiers = append(b.tiers, tier)
+	}
+	return b, nil
+}
+
+func (b *tieredBackend) ForInstance(instanceName string) (Store, error) {
+	tiers := make([]Tier, len(b.tiers))
+	for i, backend := range b.backends {
+		store, err := backend.ForInstance(instanceName)
+		if err != nil {
+			return nil, err
+		}
+		tiers[i] = b.tiers[i]
+		tiers[i].Store = store
+	}
+	return NewTiered(tiers), nil
+}
+
+func (b *tieredBackend) InstanceNames() ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, backend := range b.backends {
+		backendNames, err := backend.InstanceNames()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range backendNames {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
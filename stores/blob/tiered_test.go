@@ -0,0 +1,152 @@
+package blob
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTiered_PromotesFullyReadBlobs(t *testing.T) {
+	fast, slow := NewInMemory(0), NewInMemory(0)
+	s := NewTiered([]Tier{{Store: fast}, {Store: slow}})
+	digest := writeTestBlob(t, slow, "some blob content")
+
+	r, err := s.Read(context.TODO(), digest)
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	exists, _ := fast.Exists(context.TODO(), digest)
+	assert.False(t, exists, "partially read blob must not be promoted")
+
+	r, err = s.Read(context.TODO(), digest)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "some blob content", string(data), "read data should match written")
+	exists, _ = fast.Exists(context.TODO(), digest)
+	assert.True(t, exists, "fully read blob must be promoted")
+}
+
+func TestTiered_WritesToConfiguredTiers(t *testing.T) {
+	small, skipped, disk := NewInMemory(0), NewInMemory(0), NewInMemory(0)
+	s := NewTiered([]Tier{{Store: small, MaxBlobBytes: 10}, {Store: skipped, SkipWrites: true}, {Store: disk}})
+	smallDigest := writeTestBlob(t, s, "small")
+	largeDigest := writeTestBlob(t, s, "larger than ten bytes")
+
+	for _, tcase := range []struct {
+		name   string
+		store  Store
+		digest *remoteexecution.Digest
+		exists bool
+	}{
+		{name: "small blob in small tier", store: small, digest: smallDigest, exists: true},
+		{name: "large blob not in small tier", store: small, digest: largeDigest, exists: false},
+		{name: "no blobs in skipped tier", store: skipped, digest: smallDigest, exists: false},
+		{name: "large blob on disk", store: disk, digest: largeDigest, exists: true},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			exists, err := tcase.store.Exists(context.TODO(), tcase.digest)
+			require.NoError(t, err)
+			assert.Equal(t, tcase.exists, exists)
+		})
+	}
+
+	onlySmall := NewTiered([]Tier{{Store: small, MaxBlobBytes: 10}})
+	_, err := onlySmall.Write(context.TODO(), util.DataToContentDigest(util.SHA256, []byte("larger than ten bytes")))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "blobs no tier takes must be refused")
+}
+
+// failingStore is a Store whose writers fail after accepting maxWrites writes.
+type failingStore struct {
+	Store
+	maxWrites int
+}
+
+func (s *failingStore) Write(ctx context.Context, blobDigest *remoteexecution.Digest) (Writer, error) {
+	w, err := s.Store.Write(ctx, blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	return &failingWriter{Writer: w, remaining: s.maxWrites}, nil
+}
+
+type failingWriter struct {
+	Writer
+	remaining int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.remaining == 0 {
+		return 0, status.Errorf(codes.Unavailable, "tier is failing")
+	}
+	w.remaining--
+	return w.Writer.Write(p)
+}
+
+func TestTiered_FailedWriteIsDiscardedInAllTiers(t *testing.T) {
+	fast, slow := NewInMemory(0), NewInMemory(0)
+	s := NewTiered([]Tier{{Store: fast}, {Store: &failingStore{Store: slow, maxWrites: 1}}})
+	data := []byte("some blob content")
+	digest := util.DataToContentDigest(util.SHA256, data)
+
+	w, err := s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write(data[:4])
+	require.NoError(t, err)
+	_, err = w.Write(data[4:])
+	assert.Equal(t, codes.Unavailable, status.Code(err), "failure of the slow tier should be returned")
+	assert.Error(t, w.Close(), "close after a failed write should fail")
+	for _, tier := range []Store{fast, slow} {
+		exists, err := tier.Exists(context.TODO(), digest)
+		require.NoError(t, err)
+		assert.False(t, exists, "blob must be discarded in all tiers")
+	}
+}
+
+func TestTiered_WalkAndDeleteCoverAllTiers(t *testing.T) {
+	fast, slow := NewInMemory(0), NewInMemory(0)
+	s := NewTiered([]Tier{{Store: fast}, {Store: slow}})
+	both := writeTestBlob(t, s, "in both tiers")
+	slowOnly := writeTestBlob(t, slow, "in slow tier")
+
+	walked := make(map[string]bool)
+	require.NoError(t, s.Walk(context.TODO(), func(d *remoteexecution.Digest, _ time.Time) error {
+		assert.False(t, walked[d.Hash], "blobs must be walked once")
+		walked[d.Hash] = true
+		return nil
+	}))
+	assert.Equal(t, map[string]bool{both.Hash: true, slowOnly.Hash: true}, walked)
+
+	require.NoError(t, s.Delete(context.TODO(), both))
+	exists, err := s.Exists(context.TODO(), both)
+	require.NoError(t, err)
+	assert.False(t, exists, "deleted blob must be gone from all tiers")
+}
+
+func TestOpenTieredBackend(t *testing.T) {
+	b, err := openTieredBackend([]string{"mem://?max=1GB&tier_max_blob=1MB&tier_writes=false", "file:///var/cache/blobs?max=10GB"})
+	require.NoError(t, err)
+	require.IsType(t, &tieredBackend{}, b)
+	tiered := b.(*tieredBackend)
+	assert.Equal(t, []Tier{{SkipWrites: true, MaxBlobBytes: 1 << 20}, {}}, tiered.tiers)
+	expectedBackends := []Backend{&inMemoryBackend{maxBytes: 1 << 30}, &OnDiskBackend{basePath: "/var/cache/blobs", config: OnDiskConfig{MaxBytes: 10 << 30}}}
+	if assert.Len(t, tiered.backends, len(expectedBackends)) {
+		for i, expected := range expectedBackends {
+			assertBackendsEqual(t, expected, tiered.backends[i])
+		}
+	}
+
+	_, err = openTieredBackend([]string{"mem://?tier_writes=maybe"})
+	assert.Error(t, err, "invalid tier parameters should fail")
+}
@@ -0,0 +1,259 @@
+package fsck
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+)
+
+const (
+	// FixNone only reports problems.
+	FixNone = "none"
+	// FixQuarantine moves corrupt blobs and ActionResults to a `.quarantine` subdirectory of their store directory.
+	FixQuarantine = "quarantine"
+	// FixDelete deletes corrupt blobs and ActionResults.
+	FixDelete = "delete"
+
+	quarantineDir = ".quarantine"
+)
+
+// Problem is something wrong found in the store directories.
+type Problem struct {
+	Path        string
+	Description string
+	// Fixed is true if the file was quarantined or deleted.
+	Fixed bool
+}
+
+func (p *Problem) String() string {
+	if p.Fixed {
+		return fmt.Sprintf("%v: %v (fixed)", p.Path, p.Description)
+	}
+	return fmt.Sprintf("%v: %v", p.Path, p.Description)
+}
+
+// Report describes the state of the stores of an instance.
+type Report struct {
+	InstanceName string
+	Blobs        int
+	Actions      int
+	// StagingFiles is the number of files of interrupted writes found, which aren't problems as the stores remove
+	// them. They are removed by any fix other than FixNone.
+	StagingFiles int
+	Problems     []*Problem
+}
+
+// CheckAll checks the stores of all instances of the on-disk backends.
+// The stores mustn't be in use, as files are read, moved and removed behind their backs.
+func CheckAll(blobBackend *blob.OnDiskBackend, actionBackend *action.OnDiskBackend, fix string) ([]*Report, error) {
+	blobInstances, err := blobBackend.InstanceNames()
+	if err != nil {
+		return nil, err
+	}
+	actionInstances, err := actionBackend.InstanceNames()
+	if err != nil {
+		return nil, err
+	}
+	var reports []*Report
+	seen := make(map[string]bool)
+	for _, instanceName := range append(blobInstances, actionInstances...) {
+		if seen[instanceName] {
+			continue
+		}
+		seen[instanceName] = true
+		blobDir, err := blobBackend.InstancePath(instanceName)
+		if err != nil {
+			return reports, err
+		}
+		actionDir, err := actionBackend.InstancePath(instanceName)
+		if err != nil {
+			return reports, err
+		}
+		report, err := Check(instanceName, blobDir, actionDir, fix)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// Check re-hashes the blobs in blobDir against their names, parses the ActionResults in actionDir and checks that
+// the blobs they reference exist with the right size. Corrupt files are fixed according to fix, files that aren't
+// blobs or ActionResults and dangling references are only reported.
+func Check(instanceName string, blobDir string, actionDir string, fix string) (*Report, error) {
+	if fix != FixNone && fix != FixQuarantine && fix != FixDelete {
+		return nil, fmt.Errorf("unknown fix %q", fix)
+	}
+	c := &checker{report: &Report{InstanceName: instanceName}, fix: fix, blobSizes: make(map[string]int64)}
+	if err := c.checkBlobs(blobDir); err != nil {
+		return nil, err
+	}
+	if err := c.checkActions(actionDir); err != nil {
+		return nil, err
+	}
+	return c.report, nil
+}
+
+type checker struct {
+	report *Report
+	fix    string
+	// blobSizes holds the sizes of the intact blobs, by key.
+	blobSizes map[string]int64
+}
+
+func (c *checker) checkBlobs(dir string) error {
+	files, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		filePath := path.Join(dir, f.Name())
+		if c.isStaging(filePath) {
+			continue
+		}
+		blobDigest, err := util.KeyToContentDigest(f.Name(), f.Size())
+		if err != nil {
+			c.problem(filePath, "not a blob", false)
+			continue
+		}
+		c.report.Blobs++
+		digestFunction, err := util.DigestFunctionForHash(blobDigest.Hash)
+		if err != nil {
+			c.problem(filePath, "blob of a digest function that isn't enabled, can't verify it", false)
+			continue
+		}
+		verifier := util.NewContentVerifier(digestFunction)
+		if err := hashFile(filePath, verifier); err != nil {
+			return err
+		}
+		if err := verifier.Verify(blobDigest); err != nil {
+			c.problem(filePath, "content doesn't match the hash of the name", true)
+			continue
+		}
+		c.blobSizes[f.Name()] = f.Size()
+	}
+	return nil
+}
+
+func (c *checker) checkActions(dir string) error {
+	files, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		filePath := path.Join(dir, f.Name())
+		if c.isStaging(filePath) {
+			continue
+		}
+		if _, err := util.KeyToContentDigest(f.Name(), 0); err != nil {
+			c.problem(filePath, "not an ActionResult", false)
+			continue
+		}
+		c.report.Actions++
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("can't read %v: %v", filePath, err)
+		}
+		actionResult := &remoteexecution.ActionResult{}
+		if err := proto.Unmarshal(data, actionResult); err != nil {
+			c.problem(filePath, fmt.Sprintf("unparsable ActionResult: %v", err), true)
+			continue
+		}
+		for _, d := range action.ReferencedBlobs(actionResult) {
+			if d.SizeBytes == 0 {
+				// Clients don't upload empty blobs, so they don't need to exist.
+				continue
+			}
+			key, err := util.ContentDigestToKey(d)
+			if err != nil {
+				c.problem(filePath, fmt.Sprintf("references invalid digest %v/%d", d.Hash, d.SizeBytes), false)
+				continue
+			}
+			size, exists := c.blobSizes[key]
+			if !exists {
+				c.problem(filePath, fmt.Sprintf("references missing blob %v/%d", d.Hash, d.SizeBytes), false)
+			} else if size != d.SizeBytes {
+				c.problem(filePath, fmt.Sprintf("references blob %v/%d, which has %d bytes", d.Hash, d.SizeBytes, size), false)
+			}
+		}
+	}
+	return nil
+}
+
+// isStaging counts the file if it is one of an interrupted write, removing it unless only reporting.
+func (c *checker) isStaging(filePath string) bool {
+	if !util.IsStagingFile(path.Base(filePath)) {
+		return false
+	}
+	c.report.StagingFiles++
+	if c.fix != FixNone {
+		if err := os.Remove(filePath); err != nil {
+			c.problem(filePath, fmt.Sprintf("can't remove file of an interrupted write: %v", err), false)
+		}
+	}
+	return true
+}
+
+// problem records the problem with the file, fixing it if the file is corrupt.
+func (c *checker) problem(filePath string, description string, corrupt bool) {
+	p := &Problem{Path: filePath, Description: description}
+	if corrupt {
+		switch c.fix {
+		case FixQuarantine:
+			quarantinePath := path.Join(path.Dir(filePath), quarantineDir)
+			if err := os.MkdirAll(quarantinePath, 0777); err != nil {
+				p.Description += fmt.Sprintf(", can't quarantine: %v", err)
+			} else if err := os.Rename(filePath, path.Join(quarantinePath, path.Base(filePath))); err != nil {
+				p.Description += fmt.Sprintf(", can't quarantine: %v", err)
+			} else {
+				p.Fixed = true
+			}
+		case FixDelete:
+			if err := os.Remove(filePath); err != nil {
+				p.Description += fmt.Sprintf(", can't delete: %v", err)
+			} else {
+				p.Fixed = true
+			}
+		}
+	}
+	c.report.Problems = append(c.report.Problems, p)
+}
+
+// listFiles returns the files in the directory, skipping subdirectories (of other instances or quarantine).
+// Missing directories are empty, as stores create them on first use.
+func listFiles(dir string) ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("can't list %v: %v", dir, err)
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
+
+func hashFile(filePath string, verifier *util.ContentVerifier) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("can't open %v: %v", filePath, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(verifier, file); err != nil {
+		return fmt.Errorf("can't read %v: %v", filePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package fsck
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+)
+
+func writeFile(t *testing.T, dir string, digest *remoteexecution.Digest, data []byte) string {
+	key, err := util.ContentDigestToKey(digest)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, key), data, 0666))
+	return path.Join(dir, key)
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsck_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	blobDir, actionDir := path.Join(dir, "blobs"), path.Join(dir, "actions")
+	require.NoError(t, os.MkdirAll(blobDir, 0777))
+	require.NoError(t, os.MkdirAll(actionDir, 0777))
+
+	good := util.DataToContentDigest(util.SHA256, []byte("good"))
+	writeFile(t, blobDir, good, []byte("good"))
+	corrupt := util.DataToContentDigest(util.SHA256, []byte("corrupt"))
+	corruptPath := writeFile(t, blobDir, corrupt, []byte("c0rrupt"))
+	missing := util.DataToContentDigest(util.SHA256, []byte("missing"))
+	actionData, err := proto.Marshal(&remoteexecution.ActionResult{
+		OutputFiles:  []*remoteexecution.OutputFile{{Path: "good", Digest: good}, {Path: "corrupt", Digest: corrupt}},
+		StdoutDigest: missing,
+	})
+	require.NoError(t, err)
+	writeFile(t, actionDir, util.DataToContentDigest(util.SHA256, []byte("action")), actionData)
+	// Empty blobs are never stored, so references to them aren't dangling.
+	emptyData, err := proto.Marshal(&remoteexecution.ActionResult{
+		OutputFiles:  []*remoteexecution.OutputFile{{Path: "empty", Digest: util.DataToContentDigest(util.SHA256, nil)}},
+		StdoutDigest: util.DataToContentDigest(util.SHA256, nil),
+	})
+	require.NoError(t, err)
+	writeFile(t, actionDir, util.DataToContentDigest(util.SHA256, []byte("empty")), emptyData)
+	stagingPath := path.Join(blobDir, util.StagingPrefix+"interrupted")
+	require.NoError(t, ioutil.WriteFile(stagingPath, []byte("partial"), 0666))
+	unparsablePath := writeFile(t, actionDir, util.DataToContentDigest(util.SHA256, []byte("unparsable")), []byte("not a proto"))
+
+	report, err := Check("", blobDir, actionDir, FixQuarantine)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Blobs)
+	assert.Equal(t, 3, report.Actions)
+	assert.Equal(t, 1, report.StagingFiles)
+	var problems []string
+	for _, p := range report.Problems {
+		problems = append(problems, p.String())
+	}
+	assert.Len(t, problems, 4, "corrupt blob, two dangling references and an unparsable action expected, got: %v", problems)
+	_, err = os.Stat(stagingPath)
+	assert.True(t, os.IsNotExist(err), "files of interrupted writes should be removed")
+	for _, quarantined := range []string{corruptPath, unparsablePath} {
+		_, err := os.Stat(quarantined)
+		assert.True(t, os.IsNotExist(err), "%v should be moved out", quarantined)
+		_, err = os.Stat(path.Join(path.Dir(quarantined), quarantineDir, path.Base(quarantined)))
+		assert.NoError(t, err, "%v should be in quarantine", quarantined)
+	}
+
+	report, err = Check("", blobDir, actionDir, FixNone)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Blobs, "quarantined blobs must not be checked again")
+	assert.Equal(t, 0, report.StagingFiles)
+	assert.Len(t, report.Problems, 2, "the dangling references are left")
+}
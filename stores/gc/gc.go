@@ -0,0 +1,198 @@
+package gc
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mwitkow/bazel-distcache/common/sharedflags"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	gracePeriod = sharedflags.Set.Duration("gc_grace_period", 24*time.Hour,
+		"Minimum age of unreferenced blobs deleted by garbage collection, so that blobs uploaded ahead of their action result are kept.")
+	interval = sharedflags.Set.Duration("gc_interval", 0,
+		"Interval of background garbage collection of blobs not referenced by any action result. Disabled if 0.")
+
+	deletedBlobsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "distcache",
+			Subsystem: "gc",
+			Name:      "deleted_blobs_total",
+			Help:      "Number of unreferenced blobs deleted by garbage collection.",
+		})
+	deletedBytesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "distcache",
+			Subsystem: "gc",
+			Name:      "deleted_bytes_total",
+			Help:      "Size of unreferenced blobs deleted by garbage collection.",
+		})
+)
+
+func init() {
+	prometheus.MustRegister(deletedBlobsCounter, deletedBytesCounter)
+}
+
+// Report describes the outcome of collecting the garbage of an instance.
+type Report struct {
+	InstanceName    string
+	Actions         int
+	ReferencedBlobs int
+	Blobs           int
+	// Unreferenced are the blobs deleted, or the ones that would be deleted in a dry run.
+	Unreferenced      []*remoteexecution.Digest
+	UnreferencedBytes int64
+}
+
+// StartFromFlags collects the garbage of all instances in the background every gc_interval, if set.
+func StartFromFlags(blobStores *blob.PerInstance, actionStores *action.PerInstance) {
+	if *interval == 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(*interval) {
+			reports, err := CollectAll(context.Background(), blobStores, actionStores, false)
+			for _, r := range reports {
+				log.Infof("gc of instance %q deleted %d of %d blobs (%d bytes)", r.InstanceName, len(r.Unreferenced), r.Blobs, r.UnreferencedBytes)
+			}
+			if err != nil {
+				log.WithError(err).Errorf("gc failed")
+			}
+		}
+	}()
+}
+
+// CollectAll collects the garbage of all instances with blobs, with the grace period from flags.
+// Returns the reports of the instances done.
+func CollectAll(ctx context.Context, blobStores *blob.PerInstance, actionStores *action.PerInstance, dryRun bool) ([]*Report, error) {
+	instanceNames, err := blobStores.InstanceNames()
+	if err != nil {
+		return nil, err
+	}
+	var reports []*Report
+	for _, instanceName := range instanceNames {
+		blobStore, err := blobStores.Get(instanceName)
+		if err != nil {
+			return reports, err
+		}
+		actionStore, err := actionStores.Get(instanceName)
+		if err != nil {
+			return reports, err
+		}
+		report, err := Collect(ctx, instanceName, actionStore, blobStore, *gracePeriod, dryRun)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// Collect deletes the blobs that aren't referenced by any action result, directly or through the tree of an output
+// directory, and that were last written before the grace period. With dryRun nothing is deleted, and the report says
+// what would be.
+// Action results stored while collecting may reference old blobs that get deleted, they are treated as misses by the
+// action cache as incomplete.
+func Collect(ctx context.Context, instanceName string, actions action.Store, blobs blob.Store, gracePeriod time.Duration, dryRun bool) (*Report, error) {
+	report := &Report{InstanceName: instanceName}
+	cutoff := time.Now().Add(-gracePeriod)
+	marked := make(map[string]bool)
+	err := actions.Walk(func(_ *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error {
+		report.Actions++
+		for _, d := range action.ReferencedBlobs(actionResult) {
+			mark(marked, d)
+		}
+		for _, d := range actionResult.OutputDirectories {
+			if d.TreeDigest == nil {
+				continue
+			}
+			if err := markTree(ctx, blobs, d.TreeDigest, marked); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.ReferencedBlobs = len(marked)
+	err = blobs.Walk(ctx, func(blobDigest *remoteexecution.Digest, lastWritten time.Time) error {
+		report.Blobs++
+		key, err := util.ContentDigestToKey(blobDigest)
+		if err != nil {
+			return err
+		}
+		if !marked[key] && lastWritten.Before(cutoff) {
+			report.Unreferenced = append(report.Unreferenced, blobDigest)
+			report.UnreferencedBytes += blobDigest.SizeBytes
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return report, nil
+	}
+	for _, blobDigest := range report.Unreferenced {
+		if err := blobs.Delete(ctx, blobDigest); err != nil {
+			return nil, err
+		}
+		deletedBlobsCounter.Inc()
+		deletedBytesCounter.Add(float64(blobDigest.SizeBytes))
+	}
+	return report, nil
+}
+
+func mark(marked map[string]bool, blobDigest *remoteexecution.Digest) {
+	if key, err := util.ContentDigestToKey(blobDigest); err == nil {
+		marked[key] = true
+	}
+}
+
+// markTree marks the files and directories of the tree stored in the blob. Missing, invalid or unparsable trees have
+// no contents to keep.
+func markTree(ctx context.Context, blobs blob.Store, treeDigest *remoteexecution.Digest, marked map[string]bool) error {
+	reader, err := blobs.Read(ctx, &remoteexecution.Digest{Hash: treeDigest.Hash, SizeBytes: treeDigest.SizeBytes})
+	if code := status.Code(err); code == codes.NotFound || code == codes.InvalidArgument {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return status.Errorf(codes.Internal, "can't read tree %v: %v", treeDigest.Hash, err)
+	}
+	tree := &remoteexecution.Tree{}
+	if err := proto.Unmarshal(data, tree); err != nil {
+		log.Warnf("gc skipping unparsable tree %v: %v", treeDigest.Hash, err)
+		return nil
+	}
+	for _, dir := range append([]*remoteexecution.Directory{tree.Root}, tree.Children...) {
+		if dir == nil {
+			continue
+		}
+		for _, f := range dir.Files {
+			if f.Digest != nil {
+				mark(marked, f.Digest)
+			}
+		}
+		for _, d := range dir.Directories {
+			if d.Digest != nil {
+				mark(marked, d.Digest)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package gc
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+)
+
+func writeBlob(t *testing.T, store blob.Store, dir string, data []byte, age time.Duration) *remoteexecution.Digest {
+	digest := util.DataToContentDigest(util.SHA256, data)
+	w, err := store.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	key, err := util.ContentDigestToKey(digest)
+	require.NoError(t, err)
+	writtenAt := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(path.Join(dir, key), writtenAt, writtenAt))
+	return digest
+}
+
+func TestCollect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gc_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	blobBackend, err := blob.NewOnDiskBackend(dir, blob.OnDiskConfig{})
+	require.NoError(t, err)
+	blobs, err := blobBackend.ForInstance("")
+	require.NoError(t, err)
+	actions := action.NewInMemory()
+
+	old := 2 * time.Hour
+	output := writeBlob(t, blobs, dir, []byte("output"), old)
+	stdout := writeBlob(t, blobs, dir, []byte("stdout"), old)
+	// Clients don't need to upload empty blobs, but ones that did must be kept while referenced.
+	stderr := writeBlob(t, blobs, dir, nil, old)
+	inTree := writeBlob(t, blobs, dir, []byte("file in tree"), old)
+	treeData, err := proto.Marshal(&remoteexecution.Tree{
+		Root: &remoteexecution.Directory{Files: []*remoteexecution.FileNode{{Name: "file", Digest: inTree}}},
+	})
+	require.NoError(t, err)
+	tree := writeBlob(t, blobs, dir, treeData, old)
+	orphan := writeBlob(t, blobs, dir, []byte("orphan"), old)
+	writeBlob(t, blobs, dir, []byte("recent orphan"), 0)
+	require.NoError(t, actions.Store(util.DataToContentDigest(util.SHA256, []byte("action")), &remoteexecution.ActionResult{
+		OutputFiles:       []*remoteexecution.OutputFile{{Path: "out", Digest: output}},
+		OutputDirectories: []*remoteexecution.OutputDirectory{{Path: "dir", TreeDigest: tree}},
+		StdoutDigest:      stdout,
+		StderrDigest:      stderr,
+	}))
+
+	report, err := Collect(context.TODO(), "", actions, blobs, time.Hour, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Actions)
+	assert.Equal(t, 7, report.Blobs)
+	assert.Equal(t, []*remoteexecution.Digest{orphan}, report.Unreferenced, "only the old orphan should be collected")
+	exists, err := blobs.Exists(context.TODO(), orphan)
+	require.NoError(t, err)
+	assert.True(t, exists, "dry run must not delete anything")
+
+	report, err = Collect(context.TODO(), "", actions, blobs, time.Hour, false)
+	require.NoError(t, err)
+	assert.Equal(t, []*remoteexecution.Digest{orphan}, report.Unreferenced)
+	for _, d := range []*remoteexecution.Digest{output, stdout, stderr, inTree, tree, orphan} {
+		exists, err := blobs.Exists(context.TODO(), d)
+		require.NoError(t, err)
+		assert.Equal(t, d != orphan, exists, "only the orphan should be deleted")
+	}
+}
@@ -0,0 +1,286 @@
+// Package remote stores blobs in a remote cache (e.g. distcache) through its ContentAddressableStorage and ByteStream
+// services, so that it can be layered as the slowest tier of a tiered blob store.
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// writeChunkBytes is the size of chunks uploaded to the remote cache, well under the 4MB gRPC limit.
+	writeChunkBytes = 1024 * 1024
+)
+
+func init() {
+	blob.RegisterBackend("grpc", openBlobBackend)
+}
+
+// NewBlobStore constructs storage of the Blobs of an instance in the remote cache behind conn, under the same
+// instance name.
+// Failures to reach the remote cache are treated as misses rather than errors, like in the read-through to an
+// upstream cache. The remote cache manages its own contents, so blobs are never deleted from it nor walked.
+func NewBlobStore(conn *grpc.ClientConn, instanceName string) blob.Store {
+	return &blobStore{
+		cas:          remoteexecution.NewContentAddressableStorageClient(conn),
+		byteStream:   bytestream.NewByteStreamClient(conn),
+		instanceName: instanceName,
+	}
+}
+
+type blobBackend struct {
+	conn *grpc.ClientConn
+}
+
+// openBlobBackend opens `grpc://<host>:<port>`. Connections are established lazily, so the remote cache doesn't need
+// to be up yet.
+func openBlobBackend(backendURL *url.URL) (blob.Backend, error) {
+	if backendURL.Host == "" || (backendURL.Path != "" && backendURL.Path != "/") {
+		return nil, fmt.Errorf("blob store URL %q must only have an address, e.g. grpc://distcache:10201", backendURL.String())
+	}
+	if err := util.CheckURLQuery(backendURL); err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(backendURL.Host,
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(grpc_prometheus.UnaryClientInterceptor),
+		grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed dialing remote blob store %v: %v", backendURL.Host, err)
+	}
+	return &blobBackend{conn: conn}, nil
+}
+
+func (b *blobBackend) ForInstance(instanceName string) (blob.Store, error) {
+	return NewBlobStore(b.conn, instanceName), nil
+}
+
+func (b *blobBackend) InstanceNames() ([]string, error) {
+	// The instances of the remote cache aren't ours to manage, PerInstance knows about the instances in use.
+	return nil, nil
+}
+
+type blobStore struct {
+	cas          remoteexecution.ContentAddressableStorageClient
+	byteStream   bytestream.ByteStreamClient
+	instanceName string
+}
+
+// logMiss logs a failed remote call, unless the blob just doesn't exist, as it is treated as a miss.
+func logMiss(err error, blobDigest *remoteexecution.Digest) {
+	if status.Code(err) != codes.NotFound {
+		log.WithError(err).Warnf("remote blobstore can't reach the remote cache for blob %v, treating as a miss", blobDigest.Hash)
+	}
+}
+
+func (s *blobStore) Exists(ctx context.Context, blobDigest *remoteexecution.Digest) (bool, error) {
+	resp, err := s.cas.FindMissingBlobs(ctx, &remoteexecution.FindMissingBlobsRequest{
+		InstanceName: s.instanceName,
+		BlobDigests:  []*remoteexecution.Digest{blobDigest},
+	})
+	if err != nil {
+		logMiss(err, blobDigest)
+		return false, nil
+	}
+	return len(resp.MissingBlobDigests) == 0, nil
+}
+
+func (s *blobStore) Read(ctx context.Context, blobDigest *remoteexecution.Digest) (blob.Reader, error) {
+	readCtx, cancelRead := context.WithCancel(ctx)
+	stream, err := s.byteStream.Read(readCtx, &bytestream.ReadRequest{
+		ResourceName: util.ContentDigestToResourcePath(s.instanceName, blobDigest),
+	})
+	if err != nil {
+		cancelRead()
+		logMiss(err, blobDigest)
+		return nil, status.Errorf(codes.NotFound, "blob for contentdigest doesn't exist remotely")
+	}
+	// Missing blobs are only reported on the first receive, and Read must fail with NotFound for them.
+	r := &streamReader{
+		stream:     stream,
+		cancelRead: cancelRead,
+		digest:     &remoteexecution.Digest{Hash: blobDigest.Hash, SizeBytes: blobDigest.SizeBytes},
+	}
+	first, err := stream.Recv()
+	if err == io.EOF {
+		r.eof = true
+	} else if err != nil {
+		cancelRead()
+		logMiss(err, blobDigest)
+		return nil, status.Errorf(codes.NotFound, "blob for contentdigest doesn't exist remotely")
+	} else {
+		r.buf = first.Data
+	}
+	return r, nil
+}
+
+// Write streams the blob to the remote cache as it is written. The upload is only finished once the content is
+// verified on Close, so that mismatching blobs are never stored remotely.
+// The upload isn't bound to ctx, as writes can be resumed by later requests. It ends when the writer is closed.
+func (s *blobStore) Write(ctx context.Context, blobDigest *remoteexecution.Digest) (blob.Writer, error) {
+	digestFunction, err := util.DigestFunctionForHash(blobDigest.Hash)
+	if err != nil {
+		return nil, err
+	}
+	uploadCtx, cancelUpload := context.WithCancel(context.Background())
+	stream, err := s.byteStream.Write(uploadCtx)
+	if err != nil {
+		cancelUpload()
+		return nil, status.Errorf(codes.Unavailable, "remote blobstore can't upload blob: %v", err)
+	}
+	return &streamWriter{
+		stream:       stream,
+		cancelUpload: cancelUpload,
+		resourceName: util.ContentDigestToUploadResourcePath(s.instanceName, blobDigest),
+		digest:       blobDigest,
+		verifier:     util.NewContentVerifier(digestFunction),
+	}, nil
+}
+
+// Delete is a no-op, as the remote cache manages its own contents.
+func (s *blobStore) Delete(ctx context.Context, blobDigest *remoteexecution.Digest) error {
+	return nil
+}
+
+// Walk visits no blobs, as the remote cache manages its own contents.
+func (s *blobStore) Walk(ctx context.Context, walkFn func(blobDigest *remoteexecution.Digest, lastWritten time.Time) error) error {
+	return nil
+}
+
+// streamReader reads the chunks of a ByteStream Read, filling the buffer on each Read as required by blob.Reader.
+type streamReader struct {
+	stream     bytestream.ByteStream_ReadClient
+	cancelRead context.CancelFunc
+	digest     *remoteexecution.Digest
+	buf        []byte
+	eof        bool
+}
+
+func (r *streamReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			if r.eof {
+				return n, io.EOF
+			}
+			resp, recvErr := r.stream.Recv()
+			if recvErr == io.EOF {
+				r.eof = true
+				continue
+			} else if recvErr != nil {
+				return n, status.Errorf(codes.Unavailable, "remote blobstore can't read blob: %v", recvErr)
+			}
+			r.buf = resp.Data
+		}
+		copied := copy(p[n:], r.buf)
+		r.buf = r.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	r.cancelRead()
+	return nil
+}
+
+func (r *streamReader) Digest() *remoteexecution.Digest {
+	return r.digest
+}
+
+// streamWriter feeds the blob into a ByteStream Write, verifying the content written against the digest.
+type streamWriter struct {
+	stream       bytestream.ByteStream_WriteClient
+	cancelUpload context.CancelFunc
+	resourceName string
+	digest       *remoteexecution.Digest
+	verifier     *util.ContentVerifier
+	offset       int64
+	closed       bool
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, status.Errorf(codes.FailedPrecondition, "remote blobstore writer is closed")
+	}
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > writeChunkBytes {
+			chunk = chunk[:writeChunkBytes]
+		}
+		if err := w.send(&bytestream.WriteRequest{Data: chunk}); err != nil {
+			return written, err
+		}
+		w.verifier.Write(chunk)
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// send sends the next part of the upload, only naming the resource in the first one.
+func (w *streamWriter) send(req *bytestream.WriteRequest) error {
+	if w.offset == 0 {
+		req.ResourceName = w.resourceName
+	}
+	req.WriteOffset = w.offset
+	if err := w.stream.Send(req); err != nil {
+		if err == io.EOF {
+			// The remote cache ended the upload, the reason is only returned on receiving.
+			_, err = w.stream.CloseAndRecv()
+		}
+		return status.Errorf(status.Code(err), "remote blobstore can't upload blob: %v", err)
+	}
+	w.offset += int64(len(req.Data))
+	return nil
+}
+
+// Close finishes the write. Calling it more than once is a no-op.
+func (w *streamWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	// Cancelling an unfinished upload makes the remote cache discard it.
+	defer w.cancelUpload()
+	if err := w.verifier.Verify(w.digest); err != nil {
+		return err
+	}
+	if err := w.send(&bytestream.WriteRequest{FinishWrite: true}); err != nil {
+		return err
+	}
+	resp, err := w.stream.CloseAndRecv()
+	if err != nil {
+		return status.Errorf(status.Code(err), "remote blobstore can't upload blob: %v", err)
+	}
+	if resp.CommittedSize != w.digest.SizeBytes {
+		return status.Errorf(codes.Internal, "remote blobstore committed %d of %d bytes", resp.CommittedSize, w.digest.SizeBytes)
+	}
+	return nil
+}
+
+// Abort cancels the upload, so that the remote cache discards it.
+func (w *streamWriter) Abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.cancelUpload()
+}
+
+func (w *streamWriter) Digest() *remoteexecution.Digest {
+	return w.digest
+}
@@ -0,0 +1,165 @@
+package remote
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/service/cas"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serveRemoteCache serves a CaS of blobs in memory, returning the URL of a backend storing blobs in it.
+func serveRemoteCache(t *testing.T) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	backend, err := blob.OpenBackend("mem://")
+	require.NoError(t, err)
+	casServer, err := cas.NewLocal(cas.Config{ChunkSizeBytes: 1024, BatchMaxBytes: 1024}, blob.NewPerInstanceForBackend(backend), nil)
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	remoteexecution.RegisterContentAddressableStorageServer(server, casServer)
+	bytestream.RegisterByteStreamServer(server, casServer)
+	go server.Serve(listener)
+	return "grpc://" + listener.Addr().String(), func() {
+		server.Stop()
+		casServer.Close()
+	}
+}
+
+func writeBlob(t *testing.T, s blob.Store, data []byte) (*remoteexecution.Digest, error) {
+	digest := util.DataToContentDigest(util.SHA256, data)
+	w, err := s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	return digest, w.Close()
+}
+
+func TestBlobStore(t *testing.T) {
+	backendURL, stop := serveRemoteCache(t)
+	defer stop()
+	backend, err := blob.OpenBackend(backendURL)
+	require.NoError(t, err)
+	s, err := backend.ForInstance("some/instance")
+	require.NoError(t, err)
+
+	data := make([]byte, writeChunkBytes+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	digest, err := writeBlob(t, s, data)
+	require.NoError(t, err)
+	exists, err := s.Exists(context.TODO(), digest)
+	require.NoError(t, err)
+	assert.True(t, exists, "written blob should exist remotely")
+	r, err := s.Read(context.TODO(), digest)
+	require.NoError(t, err)
+	readData, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, data, readData, "read data should match written")
+
+	other, err := backend.ForInstance("")
+	require.NoError(t, err)
+	exists, err = other.Exists(context.TODO(), digest)
+	require.NoError(t, err)
+	assert.False(t, exists, "blobs of other instances shouldn't be visible")
+	_, err = other.Read(context.TODO(), digest)
+	assert.Equal(t, codes.NotFound, status.Code(err), "missing blob must be NotFound")
+
+	empty, err := writeBlob(t, s, nil)
+	require.NoError(t, err)
+	exists, err = s.Exists(context.TODO(), empty)
+	require.NoError(t, err)
+	assert.True(t, exists, "empty blob should exist remotely")
+}
+
+func TestBlobStore_MismatchedWriteIsDiscarded(t *testing.T) {
+	backendURL, stop := serveRemoteCache(t)
+	defer stop()
+	backend, err := blob.OpenBackend(backendURL)
+	require.NoError(t, err)
+	s, err := backend.ForInstance("")
+	require.NoError(t, err)
+
+	digest := util.DataToContentDigest(util.SHA256, []byte("some blob content"))
+	w, err := s.Write(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("some blob CONTENT"))
+	require.NoError(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(w.Close()), "close should fail verification")
+	exists, err := s.Exists(context.TODO(), digest)
+	require.NoError(t, err)
+	assert.False(t, exists, "mismatched blob must not be stored remotely")
+}
+
+func TestBlobStore_UnreachableIsMiss(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	// Nothing serves on the address once it is closed.
+	require.NoError(t, listener.Close())
+	backend, err := blob.OpenBackend("grpc://" + listener.Addr().String())
+	require.NoError(t, err)
+	s, err := backend.ForInstance("")
+	require.NoError(t, err)
+
+	digest := util.DataToContentDigest(util.SHA256, []byte("some blob content"))
+	exists, err := s.Exists(context.TODO(), digest)
+	require.NoError(t, err, "unreachable remote cache should be a miss")
+	assert.False(t, exists)
+	_, err = s.Read(context.TODO(), digest)
+	assert.Equal(t, codes.NotFound, status.Code(err), "unreachable remote cache should be a miss")
+}
+
+func TestTieredWithRemote(t *testing.T) {
+	backendURL, stop := serveRemoteCache(t)
+	defer stop()
+	remote, err := blob.OpenBackend(backendURL)
+	require.NoError(t, err)
+	remoteStore, err := remote.ForInstance("")
+	require.NoError(t, err)
+	digest, err := writeBlob(t, remoteStore, []byte("only remote"))
+	require.NoError(t, err)
+
+	memory := blob.NewInMemory(0)
+	s := blob.NewTiered([]blob.Tier{{Store: memory}, {Store: remoteStore}})
+	r, err := s.Read(context.TODO(), digest)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	exists, err := memory.Exists(context.TODO(), digest)
+	require.NoError(t, err)
+	assert.True(t, exists, "blob read from the remote tier should be promoted")
+}
+
+func TestOpenBlobBackend(t *testing.T) {
+	for _, tcase := range []struct {
+		input string
+		isErr bool
+	}{
+		{input: "grpc://distcache:10201"},
+		{input: "grpc://distcache:10201/"},
+		{input: "grpc:///blobs", isErr: true},
+		{input: "grpc://distcache:10201/blobs", isErr: true},
+		{input: "grpc://distcache:10201?max=1GB", isErr: true},
+	} {
+		t.Run(tcase.input, func(t *testing.T) {
+			backendURL, err := url.Parse(tcase.input)
+			require.NoError(t, err)
+			_, err = openBlobBackend(backendURL)
+			assert.Equal(t, tcase.isErr, err != nil, "unexpected error: %v", err)
+		})
+	}
+}
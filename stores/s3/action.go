@@ -0,0 +1,149 @@
+package s3
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/golang/protobuf/proto"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewActionStore constructs storage of the ActionResults of an instance as small objects in S3-compatible storage,
+// keyed by the action digests under the prefix of the config.
+func NewActionStore(client s3iface.S3API, config Config, instanceName string) (action.Store, error) {
+	prefix, err := instancePrefix(config, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	return &actionStore{client: client, bucket: config.Bucket, prefix: prefix}, nil
+}
+
+type actionBackend struct {
+	client s3iface.S3API
+	config Config
+}
+
+func openActionBackend(backendURL *url.URL) (action.Backend, error) {
+	config, err := ParseURL(backendURL)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &actionBackend{client: client, config: config}, nil
+}
+
+func (b *actionBackend) ForInstance(instanceName string) (action.Store, error) {
+	return NewActionStore(b.client, b.config, instanceName)
+}
+
+func (b *actionBackend) InstanceNames() ([]string, error) {
+	return listInstanceNames(context.Background(), b.client, b.config)
+}
+
+// actionStore has no context for requests, as action.Store doesn't pass one.
+type actionStore struct {
+	client s3iface.S3API
+	bucket string
+	prefix string
+}
+
+func (s *actionStore) objectKey(actionDigest *remoteexecution.Digest) (string, error) {
+	key, err := util.ContentDigestToKey(actionDigest)
+	if err != nil {
+		return "", err
+	}
+	return s.prefix + key, nil
+}
+
+func (s *actionStore) Get(actionDigest *remoteexecution.Digest) (*remoteexecution.ActionResult, error) {
+	key, err := s.objectKey(actionDigest)
+	if err != nil {
+		return nil, err
+	}
+	return s.get(context.Background(), key)
+}
+
+func (s *actionStore) get(ctx context.Context, key string) (*remoteexecution.ActionResult, error) {
+	resp, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if isNotFound(err) {
+		return nil, grpc.Errorf(codes.NotFound, "action doesnt exist")
+	} else if err != nil {
+		return nil, toStatus(err, "S3 actionstore can't read action")
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unavailable, "S3 actionstore can't read action %v: %v", key, err)
+	}
+	res := &remoteexecution.ActionResult{}
+	if err := proto.Unmarshal(content, res); err != nil {
+		return nil, grpc.Errorf(codes.Internal, "action is unparsable %v: %v", key, err)
+	}
+	return res, nil
+}
+
+// Store puts the ActionResult in a single request, so that readers see either the old or the new one.
+func (s *actionStore) Store(actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error {
+	key, err := s.objectKey(actionDigest)
+	if err != nil {
+		return err
+	}
+	content, err := proto.Marshal(actionResult)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "cannot marshal action result: %v", err)
+	}
+	_, err = s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	})
+	if err != nil {
+		return toStatus(err, "S3 actionstore can't store action")
+	}
+	return nil
+}
+
+func (s *actionStore) Delete(actionDigest *remoteexecution.Digest) error {
+	key, err := s.objectKey(actionDigest)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil && !isNotFound(err) {
+		return toStatus(err, "S3 actionstore can't delete action")
+	}
+	return nil
+}
+
+func (s *actionStore) Walk(walkFn func(actionDigest *remoteexecution.Digest, actionResult *remoteexecution.ActionResult) error) error {
+	ctx := context.Background()
+	return walkObjects(ctx, s.client, s.bucket, s.prefix, func(object *s3.Object, name string) error {
+		actionDigest, err := util.KeyToContentDigest(name, 0)
+		if err != nil {
+			log.Warnf("S3 actionstore skipping unknown object %v", aws.StringValue(object.Key))
+			return nil
+		}
+		actionResult, err := s.get(ctx, aws.StringValue(object.Key))
+		if status.Code(err) == codes.NotFound {
+			// Deleted since listed.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return walkFn(actionDigest, actionResult)
+	})
+}
@@ -0,0 +1,234 @@
+package s3
+
+import (
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewBlobStore constructs storage of the Blobs of an instance as objects in S3-compatible storage, keyed by their
+// digests under the prefix of the config.
+func NewBlobStore(client s3iface.S3API, config Config, instanceName string) (blob.Store, error) {
+	prefix, err := instancePrefix(config, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	uploader := s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		u.PartSize = config.PartSizeBytes
+	})
+	return &blobStore{client: client, uploader: uploader, bucket: config.Bucket, prefix: prefix}, nil
+}
+
+type blobBackend struct {
+	client s3iface.S3API
+	config Config
+}
+
+func openBlobBackend(backendURL *url.URL) (blob.Backend, error) {
+	config, err := ParseURL(backendURL)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &blobBackend{client: client, config: config}, nil
+}
+
+func (b *blobBackend) ForInstance(instanceName string) (blob.Store, error) {
+	return NewBlobStore(b.client, b.config, instanceName)
+}
+
+func (b *blobBackend) InstanceNames() ([]string, error) {
+	return listInstanceNames(context.Background(), b.client, b.config)
+}
+
+type blobStore struct {
+	client   s3iface.S3API
+	uploader *s3manager.Uploader
+	bucket   string
+	prefix   string
+}
+
+func (s *blobStore) objectKey(blobDigest *remoteexecution.Digest) (string, error) {
+	key, err := util.ContentDigestToKey(blobDigest)
+	if err != nil {
+		return "", err
+	}
+	return s.prefix + key, nil
+}
+
+func (s *blobStore) Exists(ctx context.Context, blobDigest *remoteexecution.Digest) (bool, error) {
+	key, err := s.objectKey(blobDigest)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if isNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, toStatus(err, "S3 blobstore can't check blob")
+	}
+	return true, nil
+}
+
+func (s *blobStore) Read(ctx context.Context, blobDigest *remoteexecution.Digest) (blob.Reader, error) {
+	key, err := s.objectKey(blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, toStatus(err, "S3 blobstore can't read blob")
+	}
+	return &objectReader{
+		body:   resp.Body,
+		digest: &remoteexecution.Digest{Hash: blobDigest.Hash, SizeBytes: aws.Int64Value(resp.ContentLength)},
+	}, nil
+}
+
+// Write streams the blob to storage as it is written, in parts for large blobs. The upload is only completed once
+// the content is verified on Close, so that mismatching blobs never become visible.
+// The upload isn't bound to ctx, as writes can be resumed by later requests. It ends when the writer is closed.
+func (s *blobStore) Write(ctx context.Context, blobDigest *remoteexecution.Digest) (blob.Writer, error) {
+	key, err := s.objectKey(blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	digestFunction, err := util.DigestFunctionForHash(blobDigest.Hash)
+	if err != nil {
+		return nil, err
+	}
+	pipeReader, pipeWriter := io.Pipe()
+	uploadCtx, cancelUpload := context.WithCancel(context.Background())
+	w := &objectWriter{
+		digest:       blobDigest,
+		pipeWriter:   pipeWriter,
+		verifier:     util.NewContentVerifier(digestFunction),
+		uploadDone:   make(chan error, 1),
+		cancelUpload: cancelUpload,
+	}
+	go func() {
+		_, err := s.uploader.UploadWithContext(uploadCtx, &s3manager.UploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+			Body:   pipeReader,
+		})
+		// Unblocks writes if the upload failed before reading everything.
+		pipeReader.CloseWithError(err)
+		w.uploadDone <- err
+	}()
+	return w, nil
+}
+
+func (s *blobStore) Delete(ctx context.Context, blobDigest *remoteexecution.Digest) error {
+	key, err := s.objectKey(blobDigest)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil && !isNotFound(err) {
+		return toStatus(err, "S3 blobstore can't delete blob")
+	}
+	return nil
+}
+
+func (s *blobStore) Walk(ctx context.Context, walkFn func(blobDigest *remoteexecution.Digest, lastWritten time.Time) error) error {
+	return walkObjects(ctx, s.client, s.bucket, s.prefix, func(object *s3.Object, name string) error {
+		blobDigest, err := util.KeyToContentDigest(name, aws.Int64Value(object.Size))
+		if err != nil {
+			log.Warnf("S3 blobstore skipping unknown object %v", aws.StringValue(object.Key))
+			return nil
+		}
+		return walkFn(blobDigest, aws.TimeValue(object.LastModified))
+	})
+}
+
+// objectReader reads the body of an object, filling the buffer on each Read as required by blob.Reader.
+type objectReader struct {
+	body   io.ReadCloser
+	digest *remoteexecution.Digest
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	n, err := io.ReadFull(r.body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *objectReader) Close() error {
+	return r.body.Close()
+}
+
+func (r *objectReader) Digest() *remoteexecution.Digest {
+	return r.digest
+}
+
+// objectWriter feeds the upload through a pipe, verifying the content written against the digest.
+type objectWriter struct {
+	digest       *remoteexecution.Digest
+	pipeWriter   *io.PipeWriter
+	verifier     *util.ContentVerifier
+	uploadDone   chan error
+	cancelUpload context.CancelFunc
+	closed       bool
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	n, err := w.pipeWriter.Write(p)
+	w.verifier.Write(p[:n])
+	if err != nil {
+		return n, toStatus(err, "S3 blobstore can't upload blob")
+	}
+	return n, nil
+}
+
+// Close finishes the write. Calling it more than once is a no-op.
+func (w *objectWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.cancelUpload()
+	if verifyErr := w.verifier.Verify(w.digest); verifyErr != nil {
+		// Failing the body makes the uploader abort, rather than complete, the upload.
+		w.pipeWriter.CloseWithError(verifyErr)
+		<-w.uploadDone
+		return verifyErr
+	}
+	w.pipeWriter.Close()
+	if err := <-w.uploadDone; err != nil {
+		return toStatus(err, "S3 blobstore can't upload blob")
+	}
+	return nil
+}
+
+// Abort makes the uploader abort, rather than complete, the upload.
+func (w *objectWriter) Abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.pipeWriter.CloseWithError(status.Errorf(codes.Aborted, "write aborted"))
+	<-w.uploadDone
+	w.cancelUpload()
+}
+
+func (w *objectWriter) Digest() *remoteexecution.Digest {
+	return w.digest
+}
@@ -0,0 +1,194 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/mwitkow/bazel-distcache/stores/action"
+	"github.com/mwitkow/bazel-distcache/stores/blob"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	instancesDir = "instances"
+)
+
+func init() {
+	blob.RegisterBackend("s3", openBlobBackend)
+	action.RegisterBackend("s3", openActionBackend)
+}
+
+// Config locates the objects of a store in S3-compatible object storage.
+type Config struct {
+	Bucket string
+	// Prefix is prepended to the keys of all objects, so that several stores can share a bucket.
+	Prefix string
+	// Region of the bucket. If empty, it is taken from the environment (AWS_REGION).
+	Region string
+	// Endpoint of S3-compatible storage other than AWS, e.g. `http://localhost:9000` for MinIO.
+	Endpoint string
+	// PathStyle puts the bucket in the path of requests rather than the host name, as needed by MinIO.
+	PathStyle bool
+	// PartSizeBytes is the size of the parts of multipart uploads of blobs, at least 5MB. Blobs smaller than a part are
+	// uploaded in a single request.
+	PartSizeBytes int64
+}
+
+// ParseURL parses `s3://<bucket>[/<prefix>][?region=<region>&endpoint=<url>&path_style=true&part_size=<size>]`.
+func ParseURL(storeURL *url.URL) (Config, error) {
+	if storeURL.Host == "" {
+		return Config{}, fmt.Errorf("S3 store URL %q must have a bucket, e.g. s3://bucket/prefix", storeURL.String())
+	}
+	if err := util.CheckURLQuery(storeURL, "region", "endpoint", "path_style", "part_size"); err != nil {
+		return Config{}, err
+	}
+	query := storeURL.Query()
+	config := Config{
+		Bucket:        storeURL.Host,
+		Prefix:        strings.Trim(storeURL.Path, "/"),
+		Region:        query.Get("region"),
+		Endpoint:      query.Get("endpoint"),
+		PartSizeBytes: s3manager.DefaultUploadPartSize,
+	}
+	if pathStyle := query.Get("path_style"); pathStyle != "" {
+		parsed, err := strconv.ParseBool(pathStyle)
+		if err != nil {
+			return Config{}, fmt.Errorf("path_style of S3 store URL %q must be true or false", storeURL.String())
+		}
+		config.PathStyle = parsed
+	}
+	if partSize := query.Get("part_size"); partSize != "" {
+		parsed, err := util.ParseByteSize(partSize)
+		if err != nil {
+			return Config{}, err
+		}
+		if parsed < s3manager.MinUploadPartSize {
+			return Config{}, fmt.Errorf("part_size of S3 store URL %q must be at least 5MB", storeURL.String())
+		}
+		config.PartSizeBytes = parsed
+	}
+	return config, nil
+}
+
+// NewClient constructs the client of the storage of the config. Credentials are taken from the environment, shared
+// config files or instance roles, as with other AWS tools.
+func NewClient(config Config) (s3iface.S3API, error) {
+	awsConfig := aws.NewConfig().WithS3ForcePathStyle(config.PathStyle)
+	if config.Region != "" {
+		awsConfig = awsConfig.WithRegion(config.Region)
+	}
+	if config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint)
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("can't create S3 session: %v", err)
+	}
+	return s3.New(sess), nil
+}
+
+// instancePrefix returns the key prefix of the objects of the instance, ending with a slash unless empty.
+func instancePrefix(config Config, instanceName string) (string, error) {
+	instancePath, err := util.InstanceNameToPath(instanceName)
+	if err != nil {
+		return "", err
+	}
+	prefix := path.Join(config.Prefix, instancePath)
+	if prefix == "" {
+		return "", nil
+	}
+	return prefix + "/", nil
+}
+
+// listInstanceNames returns the names of instances that may have objects stored, like util.InstanceNamesInPath.
+func listInstanceNames(ctx context.Context, client s3iface.S3API, config Config) ([]string, error) {
+	basePrefix, err := instancePrefix(config, "")
+	if err != nil {
+		return nil, err
+	}
+	instancesPrefix := basePrefix + instancesDir + "/"
+	names := []string{""}
+	pending := []string{instancesPrefix}
+	for len(pending) > 0 {
+		prefix := pending[0]
+		pending = pending[1:]
+		err := client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket:    aws.String(config.Bucket),
+			Prefix:    aws.String(prefix),
+			Delimiter: aws.String("/"),
+		}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+			for _, p := range page.CommonPrefixes {
+				subPrefix := aws.StringValue(p.Prefix)
+				names = append(names, strings.TrimSuffix(strings.TrimPrefix(subPrefix, instancesPrefix), "/"))
+				pending = append(pending, subPrefix)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, toStatus(err, "S3 store can't list instances")
+		}
+	}
+	return names, nil
+}
+
+// walkObjects calls walkFn for the objects directly under the prefix, skipping ones of nested instances.
+func walkObjects(ctx context.Context, client s3iface.S3API, bucket string, prefix string, walkFn func(object *s3.Object, name string) error) error {
+	var walkErr error
+	err := client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			if walkErr = walkFn(object, strings.TrimPrefix(aws.StringValue(object.Key), prefix)); walkErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	if err != nil {
+		return toStatus(err, "S3 store can't list objects")
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+// toStatus maps errors of S3 requests to gRPC ones, as expected from stores. Other than missing objects and denied
+// access, errors of object storage are mostly transient.
+func toStatus(err error, message string) error {
+	if isNotFound(err) {
+		return grpc.Errorf(codes.NotFound, "%v: %v", message, err)
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusForbidden {
+		return grpc.Errorf(codes.PermissionDenied, "%v: %v", message, err)
+	}
+	return grpc.Errorf(codes.Unavailable, "%v: %v", message, err)
+}
@@ -0,0 +1,310 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/golang/protobuf/proto"
+	"github.com/mwitkow/bazel-distcache/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/devtools/remoteexecution/v1test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testBucket = "cache"
+)
+
+// fakeS3 serves the subset of the S3 API used by the stores, for path style requests to a single bucket.
+type fakeS3 struct {
+	mu               sync.Mutex
+	objects          map[string][]byte
+	uploads          map[string]map[int][]byte
+	completedUploads int
+}
+
+type fakeObject struct {
+	Key          string
+	Size         int
+	LastModified string
+}
+
+type fakeListResult struct {
+	XMLName        xml.Name     `xml:"ListBucketResult"`
+	Name           string       `xml:"Name"`
+	Prefix         string       `xml:"Prefix"`
+	IsTruncated    bool         `xml:"IsTruncated"`
+	Contents       []fakeObject `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+func (f *fakeS3) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(req.URL.Path, "/"+testBucket+"/")
+	query := req.URL.Query()
+	body, _ := ioutil.ReadAll(req.Body)
+	switch {
+	case req.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.list(resp, query.Get("prefix"), query.Get("delimiter"))
+	case req.Method == http.MethodPost && hasParam(query, "uploads"):
+		uploadID := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(resp, "<InitiateMultipartUploadResult><Bucket>%v</Bucket><Key>%v</Key><UploadId>%v</UploadId></InitiateMultipartUploadResult>", testBucket, key, uploadID)
+	case req.Method == http.MethodPut && query.Get("uploadId") != "":
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][partNumber] = body
+		resp.Header().Set("ETag", fmt.Sprintf("\"%d\"", partNumber))
+	case req.Method == http.MethodPost && query.Get("uploadId") != "":
+		parts := f.uploads[query.Get("uploadId")]
+		var numbers []int
+		for n := range parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, n := range numbers {
+			data = append(data, parts[n]...)
+		}
+		f.objects[key] = data
+		f.completedUploads++
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprintf(resp, "<CompleteMultipartUploadResult><Bucket>%v</Bucket><Key>%v</Key></CompleteMultipartUploadResult>", testBucket, key)
+	case req.Method == http.MethodDelete && query.Get("uploadId") != "":
+		delete(f.uploads, query.Get("uploadId"))
+		resp.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPut:
+		f.objects[key] = body
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		data, exists := f.objects[key]
+		if !exists {
+			resp.WriteHeader(http.StatusNotFound)
+			if req.Method == http.MethodGet {
+				fmt.Fprint(resp, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			}
+			return
+		}
+		resp.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if req.Method == http.MethodGet {
+			resp.Write(data)
+		}
+	case req.Method == http.MethodDelete:
+		delete(f.objects, key)
+		resp.WriteHeader(http.StatusNoContent)
+	default:
+		resp.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func hasParam(query url.Values, param string) bool {
+	_, exists := query[param]
+	return exists
+}
+
+func (f *fakeS3) list(resp http.ResponseWriter, prefix string, delimiter string) {
+	result := fakeListResult{Name: testBucket, Prefix: prefix}
+	seenPrefixes := make(map[string]bool)
+	var keys []string
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			commonPrefix := prefix + rest[:i+1]
+			if !seenPrefixes[commonPrefix] {
+				seenPrefixes[commonPrefix] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, struct {
+					Prefix string `xml:"Prefix"`
+				}{commonPrefix})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, fakeObject{Key: key, Size: len(f.objects[key]), LastModified: time.Now().UTC().Format(time.RFC3339)})
+	}
+	xml.NewEncoder(resp).Encode(result)
+}
+
+func newTestClient(t *testing.T) (s3iface.S3API, *fakeS3, func()) {
+	fake := &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte)}
+	server := httptest.NewServer(fake)
+	sess, err := session.NewSession(aws.NewConfig().
+		WithEndpoint(server.URL).
+		WithRegion("us-east-1").
+		WithS3ForcePathStyle(true).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	require.NoError(t, err)
+	return s3.New(sess), fake, server.Close
+}
+
+func TestParseURL(t *testing.T) {
+	for _, tcase := range []struct {
+		input  string
+		isErr  bool
+		output Config
+	}{
+		{input: "s3://bucket", output: Config{Bucket: "bucket", PartSizeBytes: 5 << 20}},
+		{input: "s3://bucket/some/prefix/?region=eu-west-1&endpoint=http://localhost:9000&path_style=true&part_size=16MB",
+			output: Config{Bucket: "bucket", Prefix: "some/prefix", Region: "eu-west-1", Endpoint: "http://localhost:9000", PathStyle: true, PartSizeBytes: 16 << 20}},
+		{input: "s3:///prefix", isErr: true},
+		{input: "s3://bucket?part_size=1MB", isErr: true},
+		{input: "s3://bucket?path_style=sometimes", isErr: true},
+		{input: "s3://bucket?acl=public", isErr: true},
+	} {
+		t.Run(tcase.input, func(t *testing.T) {
+			storeURL, err := url.Parse(tcase.input)
+			require.NoError(t, err)
+			out, err := ParseURL(storeURL)
+			if tcase.isErr {
+				assert.Error(t, err, "should return an error")
+			} else {
+				assert.Equal(t, tcase.output, out, "should be equal")
+			}
+		})
+	}
+}
+
+func TestBlobStore(t *testing.T) {
+	client, fake, cleanup := newTestClient(t)
+	defer cleanup()
+	store, err := NewBlobStore(client, Config{Bucket: testBucket, Prefix: "blobs", PartSizeBytes: 5 << 20}, "")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	small := []byte("some blob content")
+	large := bytes.Repeat([]byte("0123456789"), 600*1024)
+	for _, data := range [][]byte{small, large} {
+		digest := util.DataToContentDigest(util.SHA256, data)
+		exists, err := store.Exists(ctx, digest)
+		require.NoError(t, err)
+		assert.False(t, exists, "blob must not exist before writing")
+		_, err = store.Read(ctx, digest)
+		assert.Equal(t, codes.NotFound, status.Code(err), "missing blob must be NotFound")
+
+		w, err := store.Write(ctx, digest)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		exists, err = store.Exists(ctx, digest)
+		require.NoError(t, err)
+		assert.True(t, exists, "blob must exist after writing")
+		r, err := store.Read(ctx, &remoteexecution.Digest{Hash: digest.Hash})
+		require.NoError(t, err)
+		assert.EqualValues(t, len(data), r.Digest().SizeBytes, "reader must expose the stored size")
+		readData, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Equal(t, data, readData, "read data should match written")
+	}
+	assert.Equal(t, 1, fake.completedUploads, "large blob should be uploaded in parts")
+
+	walked := 0
+	require.NoError(t, store.Walk(ctx, func(d *remoteexecution.Digest, _ time.Time) error {
+		walked++
+		return nil
+	}))
+	assert.Equal(t, 2, walked, "walk should visit all blobs")
+
+	smallDigest := util.DataToContentDigest(util.SHA256, small)
+	require.NoError(t, store.Delete(ctx, smallDigest))
+	exists, err := store.Exists(ctx, smallDigest)
+	require.NoError(t, err)
+	assert.False(t, exists, "deleted blob must not exist")
+}
+
+func TestBlobStore_MismatchedWriteIsDiscarded(t *testing.T) {
+	client, fake, cleanup := newTestClient(t)
+	defer cleanup()
+	store, err := NewBlobStore(client, Config{Bucket: testBucket, PartSizeBytes: 5 << 20}, "")
+	require.NoError(t, err)
+	digest := util.DataToContentDigest(util.SHA256, []byte("some blob content"))
+
+	w, err := store.Write(context.Background(), digest)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("some blob CONTENT"))
+	require.NoError(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(w.Close()), "close should fail verification")
+	assert.Empty(t, fake.objects, "mismatched blob must not be stored")
+}
+
+func TestBlobStore_WriteOutlivesContext(t *testing.T) {
+	client, fake, cleanup := newTestClient(t)
+	defer cleanup()
+	store, err := NewBlobStore(client, Config{Bucket: testBucket, PartSizeBytes: 5 << 20}, "")
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("0123456789"), 600*1024)
+	digest := util.DataToContentDigest(util.SHA256, data)
+
+	// Like a ByteStream upload that is resumed on a second stream, after the first one ended.
+	firstStreamCtx, cancelFirstStream := context.WithCancel(context.Background())
+	w, err := store.Write(firstStreamCtx, digest)
+	require.NoError(t, err)
+	_, err = w.Write(data[:1<<20])
+	require.NoError(t, err)
+	cancelFirstStream()
+	_, err = w.Write(data[1<<20:])
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	key, err := util.ContentDigestToKey(digest)
+	require.NoError(t, err)
+	assert.Equal(t, data, fake.objects[key], "resumed blob should be stored")
+}
+
+func TestActionStore(t *testing.T) {
+	client, _, cleanup := newTestClient(t)
+	defer cleanup()
+	config := Config{Bucket: testBucket, Prefix: "actions"}
+	store, err := NewActionStore(client, config, "release")
+	require.NoError(t, err)
+	actionDigest := util.DataToContentDigest(util.SHA256, []byte("action"))
+	actionResult := &remoteexecution.ActionResult{ExitCode: 1, StdoutDigest: util.DataToContentDigest(util.SHA256, []byte("out"))}
+
+	_, err = store.Get(actionDigest)
+	assert.Equal(t, codes.NotFound, status.Code(err), "missing action must be NotFound")
+	require.NoError(t, store.Store(actionDigest, actionResult))
+	stored, err := store.Get(actionDigest)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(actionResult, stored), "stored action result should be returned")
+
+	var walked []string
+	require.NoError(t, store.Walk(func(d *remoteexecution.Digest, result *remoteexecution.ActionResult) error {
+		walked = append(walked, d.Hash)
+		assert.True(t, proto.Equal(actionResult, result), "walked action result should match")
+		return nil
+	}))
+	assert.Equal(t, []string{actionDigest.Hash}, walked)
+	names, err := listInstanceNames(context.Background(), client, config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "release"}, names)
+
+	require.NoError(t, store.Delete(actionDigest))
+	_, err = store.Get(actionDigest)
+	assert.Equal(t, codes.NotFound, status.Code(err), "deleted action must be NotFound")
+}